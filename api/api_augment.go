@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/logs"
+	"novel-api/models"
+	"strings"
+	"time"
+)
+
+// AugmentRequest 图像增强请求结构体
+type AugmentRequest struct {
+	Tool           string `json:"tool"`                      // bg-removal、lineart、sketch、colorize、emotion、declutter
+	Image          string `json:"image"`                     // 图片链接、data URI 或日志ID
+	Prompt         string `json:"prompt,omitempty"`          // 提示词，仅 colorize / emotion 使用
+	Emotion        string `json:"emotion,omitempty"`         // 表情名称，仅 emotion 使用
+	Defry          int    `json:"defry,omitempty"`           // 降低强度 0-5，仅 colorize / emotion 使用
+	ResponseFormat string `json:"response_format,omitempty"` // url 或 b64_json
+}
+
+// Augment 处理 /v1/images/augment 请求，调用 NovelAI 图像增强工具
+func Augment(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 1. 获取 Authorization 请求头的值
+	authHeader := r.Header.Get("Authorization")
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+
+	// 2. 解析并校验请求体
+	var req AugmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode augment request body: %v", err)
+		writeError(w, badRequest("invalid_json", err.Error()))
+		return
+	}
+	if err := validateAugmentRequest(&req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Printf("Augment request: Tool=%s, Emotion=%s, Defry=%d, Prompt=%s", req.Tool, req.Emotion, req.Defry, truncate(req.Prompt, maxLoggedPrompt))
+
+	// 3. 读取来源图片并对齐到 64 的倍数
+	imageData, sourceLog, err := loadImage(req.Image, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sourceLog = imageSourceLog(req.Image, sourceLog)
+	width, height, err := imageSize(imageData)
+	if err != nil {
+		writeError(w, badRequest("invalid_image", err.Error()))
+		return
+	}
+	width, height = snapSize(width, height)
+	pngData, err := resizeToPNG(imageData, width, height)
+	if err != nil {
+		writeError(w, badRequest("invalid_image", err.Error()))
+		return
+	}
+
+	// 4. 调用 NovelAI 增强工具
+	images, err := models.Augment(models.AugmentRequest{
+		Tool:    req.Tool,
+		Image:   base64.StdEncoding.EncodeToString(pngData),
+		Width:   width,
+		Height:  height,
+		Prompt:  req.Prompt,
+		Emotion: req.Emotion,
+		Defry:   req.Defry,
+	}, authHeader, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 5. 上传并记录日志，关联来源日志
+	entry := logs.ImageLog{
+		Model:  "augment",
+		Action: req.Tool,
+		Prompt: req.Prompt,
+	}
+	if sourceLog != nil {
+		entry.Model = sourceLog.Model
+		entry.SourceID = sourceLog.ID
+		if entry.Prompt == "" {
+			entry.Prompt = sourceLog.Prompt
+		}
+	}
+	result := &models.GenerateResult{
+		Images:     images,
+		NamePrefix: req.Tool + "_",
+	}
+	stored := storeImages(r, entry, result, req.ResponseFormat, cfg)
+
+	data, err := toImageData(stored, "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GenerationResponse{Created: time.Now().Unix(), Data: data}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// validateAugmentRequest 校验增强工具及其专用参数
+func validateAugmentRequest(req *AugmentRequest) error {
+	if strings.TrimSpace(req.Image) == "" {
+		return badRequest("missing_image", "image is required")
+	}
+	if !containsString(models.AugmentTools, req.Tool) {
+		return badRequest("invalid_tool", fmt.Sprintf("tool must be one of: %s", strings.Join(models.AugmentTools, ", ")))
+	}
+
+	switch req.Tool {
+	case models.ToolEmotion:
+		req.Emotion = strings.ToLower(strings.TrimSpace(req.Emotion))
+		if !containsString(models.Emotions, req.Emotion) {
+			return badRequest("invalid_emotion", fmt.Sprintf("emotion must be one of: %s", strings.Join(models.Emotions, ", ")))
+		}
+		fallthrough
+	case models.ToolColorize:
+		if req.Defry < 0 || req.Defry > 5 {
+			return badRequest("invalid_defry", "defry must be between 0 and 5")
+		}
+	}
+
+	if err := normalizeResponseFormat(&req.ResponseFormat); err != nil {
+		return err
+	}
+	return nil
+}
+
+// containsString 判断切片中是否包含指定字符串
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
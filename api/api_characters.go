@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"novel-api/config"
+	"novel-api/models"
+	"regexp"
+	"strings"
+)
+
+// CharacterRequest 多角色提示词（V4 / V4.5）
+type CharacterRequest struct {
+	Prompt         string         `json:"prompt"`                    // 角色提示词
+	NegativePrompt string         `json:"negative_prompt,omitempty"` // 角色反向提示词
+	Position       string         `json:"position,omitempty"`        // 网格位置 A1-E5，列 A-E 从左到右，行 1-5 从上到下，默认 C3
+	Center         *models.Center `json:"center,omitempty"`          // 精确中心点坐标 0-1，优先于 position
+}
+
+// 默认角色位置（画面中心）
+const defaultCharacterPosition = "C3"
+
+// 聊天中的多角色语法：--char [位置] 角色提示词 [| 反向提示词]
+var charDirectiveRe = regexp.MustCompile(`(?:^|\s)--char\s`)
+
+// 下一个 -- 指令的起始位置
+var nextDirectiveRe = regexp.MustCompile(`\s--[a-z]`)
+
+// 网格位置，如 B2
+var characterPositionRe = regexp.MustCompile(`^[A-Ea-e][1-5]$`)
+
+// characterCenter 将网格位置转换为 NovelAI 的中心点坐标
+func characterCenter(position string) (models.Center, error) {
+	if position == "" {
+		position = defaultCharacterPosition
+	}
+	if !characterPositionRe.MatchString(position) {
+		return models.Center{}, fmt.Errorf("invalid character position %q, expected A1-E5", position)
+	}
+	position = strings.ToUpper(position)
+	column := float64(position[0] - 'A')
+	row := float64(position[1] - '1')
+	return models.Center{X: 0.1 + 0.2*column, Y: 0.1 + 0.2*row}, nil
+}
+
+// resolveCharacters 校验角色参数并翻译角色提示词
+func resolveCharacters(characters []CharacterRequest, model models.ModelInfo, translate string, cfg *config.Config) ([]models.CharacterPrompt, error) {
+	if len(characters) == 0 {
+		return nil, nil
+	}
+	if !model.Characters {
+		return nil, badRequest("unsupported_characters", fmt.Sprintf("model %q does not support characters, use a nai-diffusion-4 model", model.ID))
+	}
+	if len(characters) > models.MaxCharacters {
+		return nil, badRequest("invalid_characters", fmt.Sprintf("at most %d characters are supported", models.MaxCharacters))
+	}
+
+	prompts := make([]models.CharacterPrompt, 0, len(characters))
+	for i, c := range characters {
+		if strings.TrimSpace(c.Prompt) == "" {
+			return nil, badRequest("invalid_characters", fmt.Sprintf("characters[%d].prompt is required", i))
+		}
+
+		var center models.Center
+		if c.Center != nil {
+			if c.Center.X < 0 || c.Center.X > 1 || c.Center.Y < 0 || c.Center.Y > 1 {
+				return nil, badRequest("invalid_characters", fmt.Sprintf("characters[%d].center must be between 0 and 1", i))
+			}
+			center = *c.Center
+		} else {
+			parsed, err := characterCenter(c.Position)
+			if err != nil {
+				return nil, badRequest("invalid_characters", fmt.Sprintf("characters[%d]: %v", i, err))
+			}
+			center = parsed
+		}
+
+		prompts = append(prompts, models.CharacterPrompt{
+			Prompt:  translatePrompt(c.Prompt, translate, cfg),
+			UC:      translatePrompt(c.NegativePrompt, translate, cfg),
+			Center:  center,
+			Enabled: true,
+		})
+		log.Printf("Character %d: center=(%.1f, %.1f), prompt=%s", i+1, center.X, center.Y, prompts[i].Prompt)
+	}
+	return prompts, nil
+}
+
+// extractCharacters 从聊天输入中取出所有 --char 指令，返回去除指令后的场景描述
+// 每个角色的内容持续到下一个 -- 指令或输入末尾
+func extractCharacters(userInput string) (string, []CharacterRequest) {
+	var characters []CharacterRequest
+	for {
+		match := charDirectiveRe.FindStringIndex(userInput)
+		if match == nil {
+			break
+		}
+
+		end := len(userInput)
+		if next := nextDirectiveRe.FindStringIndex(userInput[match[1]:]); next != nil {
+			end = match[1] + next[0]
+		}
+		body := strings.TrimSpace(userInput[match[1]:end])
+		userInput = strings.TrimSpace(userInput[:match[0]] + " " + userInput[end:])
+
+		var c CharacterRequest
+		if fields := strings.Fields(body); len(fields) > 0 && characterPositionRe.MatchString(fields[0]) {
+			c.Position = fields[0]
+			body = strings.TrimSpace(strings.TrimPrefix(body, fields[0]))
+		}
+		if prompt, negative, found := strings.Cut(body, "|"); found {
+			c.Prompt = strings.TrimSpace(prompt)
+			c.NegativePrompt = strings.TrimSpace(negative)
+		} else {
+			c.Prompt = body
+		}
+		characters = append(characters, c)
+	}
+	return userInput, characters
+}
@@ -1,16 +1,15 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"novel-api/config"
+	"novel-api/logs"
 	"novel-api/models"
-	"regexp"
 	"strings"
-	"time"
 )
 
 // ChatRequest 定义请求结构体
@@ -25,20 +24,11 @@ type Message struct {
 	Content string `json:"content"`
 }
 
-// 提取链接的函数
-func extractLinks(userInput string) []string {
-	re := regexp.MustCompile(`https?://[^\s]+`)
-	matches := re.FindAllString(userInput, -1)
-	return matches
-}
-
 func Completions(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
 	// 1. 获取 Authorization 请求头的值
 	authHeader := r.Header.Get("Authorization")
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
 
-	fmt.Println("秘钥打印：", authHeader)
-
 	// 如果是 OPTIONS 请求,直接返回 200 OK
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -48,69 +38,171 @@ func Completions(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
 	var req config.ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Failed to decode request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, badRequest("invalid_json", err.Error()))
+		return
+	}
+
+	if err := normalizeTranslateMode(&req.Translate); err != nil {
+		writeError(w, err)
+		return
 	}
 
-	// 获取最后一条用户输入
+	// 获取最后一条用户输入，多模态消息的文本块拼接为提示词，图片块作为参考图或源图像
 	var userInput string
+	var userImages []string
 	for i := len(req.Messages) - 1; i >= 0; i-- {
 		if req.Messages[i].Role == "user" {
-			userInput = req.Messages[i].Content
-			log.Printf("User input found: %s", userInput)
+			userInput = req.Messages[i].Content.Text()
+			userImages = req.Messages[i].Content.ImageURLs()
+			log.Printf("User input found: %s (%d images)", truncate(userInput, maxLoggedPrompt), len(userImages))
 			break
 		}
 	}
 
-	// 如果启用翻译，则翻译用户输入
-	log.Printf("[Completions] Translation.Enable value: %v (URL: %s, Model: %s)", cfg.Translation.Enable, cfg.Translation.URL, cfg.Translation.Model)
-	if cfg.Translation.Enable {
-		translatedInput, err := TranslateText(userInput, cfg)
+	// 聊天指令：/upscale <图片链接|日志ID> [2|4]
+	if strings.HasPrefix(strings.TrimSpace(userInput), "/upscale") {
+		handleUpscaleCommand(newChatWriter(w, req.Model, req.Stream), r, userInput, userImages, authHeader, cfg)
+		return
+	}
+
+	// 翻译前取出 --cref、图片、生成指令与 --char，剩余文本作为正向提示词
+	prompt, err := preprocessPrompt(userInput, true, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userInput = prompt.Text
+	directives := prompt.Directives
+	if directives.Model != "" {
+		req.Model = directives.Model
+	}
+
+	// 解析模型别名，未注册的模型返回 404 model_not_found
+	info, alias, err := resolveModel(req.Model, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.Model = info.ID
+
+	var characterReference *models.CharacterReference
+	if prompt.CharacterReference != nil {
+		characterReference, err = prompt.CharacterReference.resolve(info, cfg)
 		if err != nil {
-			log.Printf("Translation failed, using original text: %v", err)
-		} else {
-			log.Printf("Translation enabled, translated: %s -> %s", userInput, translatedInput)
-			userInput = translatedInput
+			writeError(w, err)
+			return
 		}
-	} else {
-		log.Printf("[Completions] Translation is disabled, skipping translation")
+	}
+	characters, err := resolveCharacters(prompt.Characters, info, req.Translate, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	// 提取用户输入中的链接
-	imageURL := extractLinks(userInput)
-	var base64String string
-	if len(imageURL) > 0 {
-		// 选择第一个提取到的链接
-		imageURLS := imageURL[0]
-		// 解析图片为bash
-		base64String, _ = ImageURLToBase64(imageURLS)
-		//if err != nil {
-		//	log.Fatalf("Error: %v", err)
-		//}
+	// 按翻译模式翻译用户输入与 --no 的反向提示词
+	userInput = translatePrompt(userInput, req.Translate, cfg)
+	directives.Overrides.ExtraNegativePrompt = translatePrompt(directives.Overrides.ExtraNegativePrompt, req.Translate, cfg)
+
+	// 使用 --seed 指令中的种子，未指定时随机生成
+	seed := newSeed()
+	if directives.Seed != nil {
+		seed = *directives.Seed
 	}
+	log.Printf("Using seed: %d", seed)
 
-	// 调用翻译,翻译为专用提示词
+	// 使用别名预设或模型的默认尺寸，--ar 指令按该尺寸的像素总数换算
+	width, height := aliasSize(alias, models.ModelDefaults(req.Model, cfg))
+	if directives.AspectRatio != "" {
+		width, height, _ = aspectRatioSize(directives.AspectRatio, width, height)
+	}
 
-	// 生成一个随机种子
-	rand.Seed(time.Now().UnixNano()) // 使用当前时间的纳秒数作为随机数生成器的种子
-	randomSeed := rand.Intn(1000000) // 生成一个0到999999之间的随机数
+	// 图片来源：依次为 image_url 内容块、提示词中的图片（可附带 |强度|信息提取量）
+	imageSources := make([]ReferenceRequest, 0, len(userImages)+len(prompt.References))
+	for _, image := range userImages {
+		imageSources = append(imageSources, ReferenceRequest{Image: image})
+	}
+	imageSources = append(imageSources, prompt.References...)
 
-	// 根据模型来请求 url
-	if req.Model == "nai-diffusion-3" {
-		models.Nai3(w, r, req, randomSeed, base64String, authHeader, cfg, userInput)
+	// 图生图：指定 --strength 时第一张图片作为源图像，缩放到目标尺寸
+	action := models.ActionGenerate
+	var sourceImage string
+	var strength float64
+	if directives.Strength != nil {
+		if len(imageSources) == 0 {
+			writeError(w, badRequest("missing_image", "--strength requires an image, send it as an image_url content part or a link"))
+			return
+		}
+		if !info.Img2Img {
+			writeError(w, badRequest("unsupported_action", fmt.Sprintf("model %q does not support img2img", req.Model)))
+			return
+		}
+		data, _, err := loadImage(imageSources[0].Image, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		resized, err := resizeToPNG(data, width, height)
+		if err != nil {
+			writeError(w, badRequest("invalid_image", err.Error()))
+			return
+		}
+		action = models.ActionImg2Img
+		sourceImage = base64.StdEncoding.EncodeToString(resized)
+		strength = *directives.Strength
+		imageSources = imageSources[1:]
 	}
-	if req.Model == "nai-diffusion-furry-3" {
-		models.Nai3(w, r, req, randomSeed, base64String, authHeader, cfg, userInput)
+
+	// 其余图片均作为参考图
+	references, err := resolveReferences(imageSources, info, width, height, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	if req.Model == "nai-diffusion-4-full" {
-		models.Nai4(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, nil)
+
+	gen := models.GenerateRequest{
+		Model:      req.Model,
+		Prompt:     userInput,
+		Seed:       seed,
+		Width:      width,
+		Height:     height,
+		NSamples:   cfg.Parameters.NSamples,
+		References: references,
+		Action:     action,
+		Image:      sourceImage,
+		Strength:   strength,
+
+		CharacterPrompts:   characters,
+		CharacterReference: characterReference,
+		Overrides:          aliasOverrides(alias).Merge(&directives.Overrides),
 	}
-	if req.Model == "nai-diffusion-4-curated-preview" {
-		models.Nai4(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, nil)
+
+	// 流式请求中 V4 模型可通过流式接口实时转发生成进度
+	reply := newChatWriter(w, req.Model, req.Stream)
+	if info.Family == models.FamilyV4 {
+		gen.Progress = reply.progress(cfg)
 	}
-	if req.Model == "nai-diffusion-4-5-curated" {
-		models.Nai4(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, nil)
+
+	// 根据模型族调用相应的生成函数，等待期间保持连接
+	stopKeepAlive := reply.keepAlive()
+	result, err := info.Generator()(gen, authHeader, cfg)
+	if err != nil {
+		stopKeepAlive()
+		reply.fail(err)
+		return
 	}
-	if req.Model == "nai-diffusion-4-5-full" {
-		models.Nai4(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, nil)
+
+	// 上传图片并返回链接
+	stored := storeImages(r, logs.ImageLog{Model: req.Model, Action: action, Prompt: userInput}, result, ResponseFormatURL, cfg)
+	stopKeepAlive()
+	writeChatImages(reply, markdownLinks(stored))
+}
+
+// writeChatImages 以聊天格式返回图片链接，多张图片以空行分隔，已输出进度时另起一段
+func writeChatImages(reply *chatWriter, publicLinks []string) {
+	content := strings.Join(publicLinks, "\n\n")
+	if reply.hasContent() {
+		content = "\n" + content
 	}
+	reply.send(content)
+	reply.finish()
 }
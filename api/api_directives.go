@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"novel-api/config"
+	"novel-api/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 最大种子值（NovelAI 使用 32 位无符号种子）
+const maxSeed = math.MaxUint32
+
+// 宽高比允许的最大长边/短边比例
+const maxAspectRatio = 4.0
+
+// 聊天中的生成指令：--ar 16:9、--seed 42、--steps 23、--scale 6、--sampler k_euler、--no hands、--model nai-diffusion-3、--strength 0.6
+var directiveRe = regexp.MustCompile(`(?:^|\s)--(ar|seed|steps|scale|sampler|no|model|strength)(?:\s+|$)`)
+
+// chatDirectives 聊天输入中解析出的单次生成参数
+type chatDirectives struct {
+	Model       string // 为空时使用请求中的模型
+	AspectRatio string // 宽高比，如 16:9，为空时使用模型的默认尺寸
+	Seed        *int
+	Strength    *float64 // 图生图重绘强度，设置后以第一张图片作为源图像
+	Overrides   models.Overrides
+}
+
+// extractDirectives 从聊天输入中取出生成指令，返回去除指令后的文本
+// --no 的内容持续到下一个 -- 指令或输入末尾，其余指令只取一个值
+func extractDirectives(userInput string, cfg *config.Config) (string, *chatDirectives, error) {
+	d := &chatDirectives{}
+	for {
+		match := directiveRe.FindStringSubmatchIndex(userInput)
+		if match == nil {
+			break
+		}
+		name := userInput[match[2]:match[3]]
+		rest := userInput[match[1]:]
+
+		var value string
+		if name == "no" {
+			end := len(rest)
+			if next := nextDirectiveRe.FindStringIndex(rest); next != nil {
+				end = next[0]
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		} else {
+			// 正则已吞掉指令后的空白，rest 以值开头
+			if fields := strings.Fields(rest); len(fields) > 0 && !strings.HasPrefix(fields[0], "--") {
+				value = fields[0]
+				rest = rest[len(value):]
+			}
+		}
+		if value == "" {
+			return userInput, nil, badRequest("invalid_directive", fmt.Sprintf("--%s requires a value", name))
+		}
+		if err := d.set(name, value, cfg); err != nil {
+			return userInput, nil, err
+		}
+		userInput = strings.TrimSpace(userInput[:match[0]] + " " + strings.TrimSpace(rest))
+	}
+	return userInput, d, nil
+}
+
+// set 校验并记录单个指令
+func (d *chatDirectives) set(name, value string, cfg *config.Config) error {
+	switch name {
+	case "ar":
+		// 尺寸取决于最终模型的默认尺寸，此处只校验比例
+		if _, _, err := aspectRatioSize(value, cfg.Parameters.Width, cfg.Parameters.Height); err != nil {
+			return badRequest("invalid_directive", err.Error())
+		}
+		d.AspectRatio = value
+	case "seed":
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seed < 0 || seed > maxSeed {
+			return badRequest("invalid_directive", fmt.Sprintf("--seed must be an integer between 0 and %d", uint32(maxSeed)))
+		}
+		s := int(seed)
+		d.Seed = &s
+	case "steps":
+		steps, err := strconv.Atoi(value)
+		if err != nil {
+			return badRequest("invalid_directive", "--steps must be an integer")
+		}
+		d.Overrides.Steps = &steps
+	case "scale":
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return badRequest("invalid_directive", "--scale must be a number")
+		}
+		d.Overrides.Scale = &scale
+	case "sampler":
+		sampler := strings.ToLower(value)
+		d.Overrides.Sampler = &sampler
+	case "no":
+		if d.Overrides.ExtraNegativePrompt != "" {
+			value = d.Overrides.ExtraNegativePrompt + ", " + value
+		}
+		d.Overrides.ExtraNegativePrompt = value
+	case "model":
+		if _, _, err := resolveModel(value, cfg); err != nil {
+			return err
+		}
+		d.Model = value
+	case "strength":
+		strength, err := strconv.ParseFloat(value, 64)
+		if err != nil || strength < 0.01 || strength > 0.99 {
+			return badRequest("invalid_directive", "--strength must be a number between 0.01 and 0.99")
+		}
+		d.Strength = &strength
+	}
+	if err := validateOverrides(&d.Overrides); err != nil {
+		return err
+	}
+	log.Printf("Chat directive --%s %s", name, value)
+	return nil
+}
+
+// validateOverrides 校验单次请求覆盖的 NovelAI 参数
+func validateOverrides(o *models.Overrides) error {
+	if o.Steps != nil && (*o.Steps < 1 || *o.Steps > models.MaxSteps) {
+		return badRequest("invalid_steps", fmt.Sprintf("steps must be between 1 and %d", models.MaxSteps))
+	}
+	if o.Scale != nil && (*o.Scale < 0 || *o.Scale > models.MaxScale) {
+		return badRequest("invalid_scale", fmt.Sprintf("scale must be between 0 and %g", models.MaxScale))
+	}
+	if o.Sampler != nil && !containsString(models.Samplers, *o.Sampler) {
+		return badRequest("invalid_sampler", fmt.Sprintf("sampler must be one of: %s", strings.Join(models.Samplers, ", ")))
+	}
+	if o.NoiseSchedule != nil && !containsString(models.NoiseSchedules, *o.NoiseSchedule) {
+		return badRequest("invalid_noise_schedule", fmt.Sprintf("noise_schedule must be one of: %s", strings.Join(models.NoiseSchedules, ", ")))
+	}
+	if o.CFGRescale != nil && (*o.CFGRescale < 0 || *o.CFGRescale > models.MaxCFGRescale) {
+		return badRequest("invalid_cfg_rescale", fmt.Sprintf("cfg_rescale must be between 0 and %g", models.MaxCFGRescale))
+	}
+	return nil
+}
+
+// aspectRatioSize 按宽高比计算尺寸，像素总数与模型默认尺寸保持一致并对齐到 64 的倍数
+func aspectRatioSize(ratio string, defaultWidth, defaultHeight int) (int, int, error) {
+	var w, h float64
+	if _, err := fmt.Sscanf(ratio, "%g:%g", &w, &h); err != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid --ar %q, expected format W:H", ratio)
+	}
+	if math.Max(w, h)/math.Min(w, h) > maxAspectRatio {
+		return 0, 0, fmt.Errorf("--ar %q is too extreme, at most %g:1", ratio, maxAspectRatio)
+	}
+
+	area := float64(defaultWidth * defaultHeight)
+	scaled := math.Sqrt(area * w / h)
+	width, height := snapSize(int(scaled), int(scaled*h/w))
+	return width, height, nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"novel-api/config"
+	"novel-api/models"
+	"reflect"
+	"testing"
+)
+
+// ptr 返回值的指针，用于构造测试期望
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Parameters.Width = 832
+	cfg.Parameters.Height = 1216
+	return cfg
+}
+
+func TestExtractDirectives(t *testing.T) {
+	tests := []struct {
+		input   string
+		rest    string
+		want    chatDirectives
+		wantErr bool
+	}{
+		{"一个女孩", "一个女孩", chatDirectives{}, false},
+		{"一个女孩 --ar 16:9 --seed 42", "一个女孩", chatDirectives{AspectRatio: "16:9", Seed: ptr(42)}, false},
+		{"--seed 0 一个女孩", "一个女孩", chatDirectives{Seed: ptr(0)}, false},
+		{"一个女孩 --steps 23 --scale 6 --sampler K_Euler", "一个女孩", chatDirectives{Overrides: models.Overrides{Steps: ptr(23), Scale: ptr(6.0), Sampler: ptr("k_euler")}}, false},
+		{"一个女孩 --strength 0.5", "一个女孩", chatDirectives{Strength: ptr(0.5)}, false},
+		{"一个女孩 --model nai-diffusion-3", "一个女孩", chatDirectives{Model: "nai-diffusion-3"}, false},
+
+		// --no 持续到下一个指令，可出现多次
+		{"一个女孩 --no 多余的手指, 模糊 --seed 1", "一个女孩", chatDirectives{Seed: ptr(1), Overrides: models.Overrides{ExtraNegativePrompt: "多余的手指, 模糊"}}, false},
+		{"一个女孩 --no hands --no text", "一个女孩", chatDirectives{Overrides: models.Overrides{ExtraNegativePrompt: "hands, text"}}, false},
+
+		// 不是指令
+		{"a--ar 16:9", "a--ar 16:9", chatDirectives{}, false},
+
+		// 错误的值
+		{"一个女孩 --seed", "", chatDirectives{}, true},
+		{"一个女孩 --seed --steps 20", "", chatDirectives{}, true},
+		{"一个女孩 --seed -1", "", chatDirectives{}, true},
+		{"一个女孩 --seed 4294967296", "", chatDirectives{}, true},
+		{"一个女孩 --ar 16x9", "", chatDirectives{}, true},
+		{"一个女孩 --ar 5:1", "", chatDirectives{}, true},
+		{"一个女孩 --steps 100", "", chatDirectives{}, true},
+		{"一个女孩 --scale big", "", chatDirectives{}, true},
+		{"一个女孩 --sampler euler_magic", "", chatDirectives{}, true},
+		{"一个女孩 --strength 1", "", chatDirectives{}, true},
+		{"一个女孩 --model dall-e-3", "", chatDirectives{}, true},
+	}
+	cfg := testConfig()
+	for _, tt := range tests {
+		rest, d, err := extractDirectives(tt.input, cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("extractDirectives(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if rest != tt.rest || !reflect.DeepEqual(*d, tt.want) {
+			t.Errorf("extractDirectives(%q) = %q, %+v, want %q, %+v", tt.input, rest, *d, tt.rest, tt.want)
+		}
+	}
+}
+
+func TestAspectRatioSize(t *testing.T) {
+	tests := []struct {
+		ratio         string
+		width, height int
+	}{
+		{"1:1", 1024, 1024},
+		{"2:3", 832, 1216},
+		{"16:9", 1344, 768},
+		{"9:16", 768, 1344},
+	}
+	for _, tt := range tests {
+		width, height, err := aspectRatioSize(tt.ratio, 832, 1216)
+		if err != nil {
+			t.Errorf("aspectRatioSize(%q) error: %v", tt.ratio, err)
+			continue
+		}
+		if width != tt.width || height != tt.height {
+			t.Errorf("aspectRatioSize(%q) = %dx%d, want %dx%d", tt.ratio, width, height, tt.width, tt.height)
+		}
+	}
+}
+
+func TestExtractCharacters(t *testing.T) {
+	tests := []struct {
+		input      string
+		rest       string
+		characters []CharacterRequest
+	}{
+		{"两个女孩在公园", "两个女孩在公园", nil},
+		{"两个女孩在公园 --char 银发女孩", "两个女孩在公园", []CharacterRequest{{Prompt: "银发女孩"}}},
+		{
+			"两个女孩在公园 --char B3 银发女孩 | 眼镜 --char d3 黑发女孩",
+			"两个女孩在公园",
+			[]CharacterRequest{
+				{Prompt: "银发女孩", NegativePrompt: "眼镜", Position: "B3"},
+				{Prompt: "黑发女孩", Position: "d3"},
+			},
+		},
+		// 不是网格位置时作为提示词的一部分
+		{"--char F6 girl", "", []CharacterRequest{{Prompt: "F6 girl"}}},
+	}
+	for _, tt := range tests {
+		rest, characters := extractCharacters(tt.input)
+		if rest != tt.rest || !reflect.DeepEqual(characters, tt.characters) {
+			t.Errorf("extractCharacters(%q) = %q, %+v, want %q, %+v", tt.input, rest, characters, tt.rest, tt.characters)
+		}
+	}
+}
+
+func TestCharacterCenter(t *testing.T) {
+	tests := []struct {
+		position string
+		want     models.Center
+		wantErr  bool
+	}{
+		{"", models.Center{X: 0.5, Y: 0.5}, false},
+		{"A1", models.Center{X: 0.1, Y: 0.1}, false},
+		{"e5", models.Center{X: 0.9, Y: 0.9}, false},
+		{"F1", models.Center{}, true},
+		{"A6", models.Center{}, true},
+	}
+	for _, tt := range tests {
+		got, err := characterCenter(tt.position)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("characterCenter(%q) error = %v, wantErr %v", tt.position, err, tt.wantErr)
+			continue
+		}
+		const epsilon = 1e-9
+		if d := got.X - tt.want.X; d > epsilon || d < -epsilon {
+			t.Errorf("characterCenter(%q) = %+v, want %+v", tt.position, got, tt.want)
+		} else if d := got.Y - tt.want.Y; d > epsilon || d < -epsilon {
+			t.Errorf("characterCenter(%q) = %+v, want %+v", tt.position, got, tt.want)
+		}
+	}
+}
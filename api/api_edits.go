@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/models"
+	"strconv"
+	"strings"
+)
+
+// maxUploadMemory multipart 表单在内存中保留的最大字节数
+const maxUploadMemory = 32 << 20
+
+// 图生图默认重绘强度
+const defaultImg2ImgStrength = 0.7
+
+// Edits 处理 OpenAI 兼容的 /v1/images/edits 请求：有蒙版时局部重绘 (infill)，否则图生图 (img2img)
+func Edits(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 1. 获取 Authorization 请求头的值
+	authHeader := r.Header.Get("Authorization")
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+
+	// 2. 解析 multipart 表单
+	req, err := decodeImageForm(w, r, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req.mask, err = readFormFile(r, "mask", false, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 3. 有蒙版时局部重绘，否则图生图
+	if req.mask != nil {
+		req.action = models.ActionInfill
+		req.strength, err = formFloat(r, "strength", inpaintStrength(cfg), 0.01, 1)
+	} else {
+		req.action = models.ActionImg2Img
+		req.strength, err = formFloat(r, "strength", defaultImg2ImgStrength, 0.01, 0.99)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.noise, err = formFloat(r, "noise", 0, 0, 0.99)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Printf("Edit request: Model=%s, Action=%s, Strength=%.2f, Noise=%.2f, Prompt=%s", req.Model, req.action, req.strength, req.noise, truncate(req.Prompt, maxLoggedPrompt))
+
+	// 4. 调用生成流程
+	response, err := runGeneration(r, req, authHeader, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// decodeImageForm 解析图像类 multipart 请求的公共字段：image、prompt、model、n、size、response_format、translate、nai_parameters、references
+func decodeImageForm(w http.ResponseWriter, r *http.Request, cfg *config.Config) (GenerationRequest, error) {
+	var req GenerationRequest
+	// 请求体最多包含源图像与蒙版两张图片，其余为普通字段
+	maxSize, _ := fetchLimits(cfg)
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxSize+maxUploadMemory)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		log.Printf("Failed to parse multipart form: %v", err)
+		return req, badRequest("invalid_form", "request must be multipart/form-data: "+err.Error())
+	}
+
+	req.Model = r.FormValue("model")
+	req.Prompt = r.FormValue("prompt")
+	req.Size = r.FormValue("size")
+	req.ResponseFormat = r.FormValue("response_format")
+	req.Translate = r.FormValue("translate")
+	if n := r.FormValue("n"); n != "" {
+		parsed, err := strconv.Atoi(n)
+		if err != nil {
+			return req, badRequest("invalid_n", fmt.Sprintf("invalid n %q", n))
+		}
+		req.N = parsed
+	}
+
+	// nai_parameters 以 JSON 字符串传入
+	if params := r.FormValue("nai_parameters"); params != "" {
+		req.NAIParameters = &NAIParameters{}
+		if err := json.Unmarshal([]byte(params), req.NAIParameters); err != nil {
+			return req, badRequest("invalid_nai_parameters", "nai_parameters must be a JSON object: "+err.Error())
+		}
+	}
+
+	// references 以 JSON 数组字符串传入
+	if refs := r.FormValue("references"); refs != "" {
+		if err := json.Unmarshal([]byte(refs), &req.References); err != nil {
+			return req, badRequest("invalid_reference", "references must be a JSON array: "+err.Error())
+		}
+	}
+
+	image, err := readFormFile(r, "image", true, cfg)
+	if err != nil {
+		return req, err
+	}
+	req.image = image
+
+	// 未指定 size 时使用源图像尺寸（对齐到 64 的倍数）
+	if req.Size == "" {
+		width, height, err := imageSize(image)
+		if err != nil {
+			return req, badRequest("invalid_image", err.Error())
+		}
+		width, height = snapSize(width, height)
+		req.Size = fmt.Sprintf("%dx%d", width, height)
+	}
+
+	if err := validateGenerationRequest(&req, cfg); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// readFormFile 读取 multipart 表单中的图片字段，与图片链接相同的大小、尺寸与格式限制，非 PNG 图片转换为 PNG
+func readFormFile(r *http.Request, field string, required bool, cfg *config.Config) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		if required {
+			return nil, badRequest("missing_"+field, field+" is required")
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, badRequest("invalid_"+field, err.Error())
+	}
+	defer file.Close()
+
+	// 多读一个字节用于判断是否超限
+	maxSize, _ := fetchLimits(cfg)
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, badRequest("invalid_"+field, err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return nil, badRequest("invalid_"+field, fmt.Sprintf("%s is larger than %d bytes", field, maxSize))
+	}
+	if err := checkImagePixels(data, cfg); err != nil {
+		return nil, badRequest("invalid_"+field, err.Error())
+	}
+	converted, err := toPNG(data)
+	if err != nil {
+		return nil, badRequest("invalid_"+field, err.Error())
+	}
+	return converted, nil
+}
+
+// formFloat 读取表单中的浮点数字段并校验范围，未传时返回默认值
+func formFloat(r *http.Request, field string, def, minValue, maxValue float64) (float64, error) {
+	value := r.FormValue(field)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < minValue || parsed > maxValue {
+		return 0, badRequest("invalid_"+field, fmt.Sprintf("%s must be a number between %g and %g", field, minValue, maxValue))
+	}
+	return parsed, nil
+}
+
+// inpaintStrength 局部重绘默认强度，取自配置 inpaintImg2ImgStrength，未配置或超出范围时为 1
+func inpaintStrength(cfg *config.Config) float64 {
+	if cfg.Parameters.InpaintImg2ImgStrength <= 0 || cfg.Parameters.InpaintImg2ImgStrength > 1 {
+		return 1
+	}
+	return cfg.Parameters.InpaintImg2ImgStrength
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"novel-api/models"
+)
+
+// APIError OpenAI 兼容的错误，返回给调用方
+type APIError struct {
+	Status  int    `json:"-"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse OpenAI 兼容的错误响应结构
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// badRequest 构造 400 参数错误
+func badRequest(code, message string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Message: message, Type: "invalid_request_error", Code: code}
+}
+
+// writeError 以 OpenAI 错误格式写出响应，上游 NovelAI 错误会保留其状态码
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	var naiErr *models.APIError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &naiErr):
+		status := naiErr.StatusCode
+		if status < 400 || status >= 500 {
+			status = http.StatusBadGateway
+		}
+		apiErr = &APIError{Status: status, Message: naiErr.Error(), Type: "upstream_error", Code: "novelai_error"}
+	default:
+		apiErr = &APIError{Status: http.StatusInternalServerError, Message: err.Error(), Type: "server_error"}
+	}
+
+	log.Printf("Request failed with status %d: %s", apiErr.Status, apiErr.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr})
+}
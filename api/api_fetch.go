@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"novel-api/config"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 图片下载未配置时的默认限制
+const (
+	defaultFetchMaxSize = 10 << 20 // 10 MB
+	defaultFetchTimeout = 20 * time.Second
+	defaultMaxPixels    = 4096 * 4096
+	maxFetchRedirects   = 5
+)
+
+// 允许下载的图片类型，按文件内容识别
+var fetchImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// 运营商级 NAT 地址段，net.IP.IsPrivate 不包含
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// errPrivateAddress 目标地址为内网、回环等私有地址
+var errPrivateAddress = errors.New("host resolves to a private or reserved address")
+
+// fetchLimits 返回图片下载的大小与超时限制
+func fetchLimits(cfg *config.Config) (int64, time.Duration) {
+	maxSize := int64(cfg.ImageFetch.MaxSize)
+	if maxSize <= 0 {
+		maxSize = defaultFetchMaxSize
+	}
+	timeout := time.Duration(cfg.ImageFetch.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	return maxSize, timeout
+}
+
+// fetchImage 下载图片内容
+// 只允许 http(s)，限制大小与超时，拒绝解析到私有地址的域名（private_hosts 与已配置的存储服务除外），并校验内容是否为图片
+func fetchImage(imageURL string, cfg *config.Config) ([]byte, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid image url")
+	}
+	if !hostAllowed(u.Hostname(), cfg) {
+		return nil, fmt.Errorf("host %q is not in image_fetch.allowed_hosts", u.Hostname())
+	}
+
+	maxSize, timeout := fetchLimits(cfg)
+	client := newFetchClient(timeout, cfg)
+
+	// 发送HTTP GET请求
+	resp, err := client.Get(imageURL)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, errPrivateAddress
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+		if !strings.HasPrefix(mediaType, "image/") && mediaType != "application/octet-stream" {
+			return nil, fmt.Errorf("unsupported content type %q", mediaType)
+		}
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+
+	// 读取响应体，多读一个字节用于判断是否超限
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+	if err := checkImageType(data); err != nil {
+		return nil, err
+	}
+	log.Printf("Fetched image %s: %d bytes", u.Host, len(data))
+	return data, nil
+}
+
+// checkImageType 按文件内容校验图片格式
+func checkImageType(data []byte) error {
+	detected := http.DetectContentType(data)
+	if !containsString(fetchImageTypes, detected) {
+		return fmt.Errorf("unsupported image type %q, expected PNG, JPEG, GIF or WebP", detected)
+	}
+	return nil
+}
+
+// checkImagePixels 只读取图片头部的尺寸，拒绝超过 image_fetch.max_pixels 的图片，须在完整解码前调用
+// 几 KB 的 PNG 即可声明 30000×30000 的尺寸，直接解码会尝试分配数 GB 内存
+func checkImagePixels(data []byte, cfg *config.Config) error {
+	width, height, err := imageSize(data)
+	if err != nil {
+		return err
+	}
+	maxPixels := int64(cfg.ImageFetch.MaxPixels)
+	if maxPixels <= 0 {
+		maxPixels = defaultMaxPixels
+	}
+	if int64(width)*int64(height) > maxPixels {
+		return fmt.Errorf("image is %dx%d, larger than %d pixels", width, height, maxPixels)
+	}
+	return nil
+}
+
+// newFetchClient 创建带超时与私有地址检查的 HTTP 客户端
+// 检查在建立连接时对实际 IP 进行，跳转与 DNS 重绑定同样受限；不使用环境变量中的代理，以免绕过检查
+func newFetchClient(timeout time.Duration, cfg *config.Config) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	guarded := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if hostTrusted(host, cfg) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			return guarded.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		DisableKeepAlives:     true,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if !hostAllowed(req.URL.Hostname(), cfg) {
+				return fmt.Errorf("redirect to host %q is not in image_fetch.allowed_hosts", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// isPrivateIP 判断是否为内网、回环、链路本地等不应从外部访问的地址
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierNAT.Contains(ip)
+}
+
+// hostAllowed 未配置 allowed_hosts 时允许所有域名
+func hostAllowed(host string, cfg *config.Config) bool {
+	if len(cfg.ImageFetch.AllowedHosts) == 0 {
+		return true
+	}
+	return matchHost(host, cfg.ImageFetch.AllowedHosts)
+}
+
+// hostTrusted 是否允许解析到私有地址：private_hosts 与已配置的存储服务域名
+func hostTrusted(host string, cfg *config.Config) bool {
+	if matchHost(host, cfg.ImageFetch.PrivateHosts) {
+		return true
+	}
+	for _, base := range []string{cfg.TencentCOS.BaseURL, cfg.Minio.BaseURL, cfg.Alist.BaseURL, cfg.Lsky.BaseURL} {
+		if u, err := url.Parse(base); err == nil && u.Hostname() != "" && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost 匹配域名列表，支持 *.example.com 形式的通配
+func matchHost(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
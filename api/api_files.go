@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/logs"
+	"novel-api/upload"
+	"regexp"
+	"time"
+)
+
+// 上传文件的 ID 前缀，其后为日志 ID
+const fileIDPrefix = "file-"
+
+// 上传文件 ID，如 file-20251001123045abc123（日志 ID 为 14 位时间 + 6 位随机字符）
+const fileIDPattern = `file-\d{14}[a-z0-9]{6}`
+
+// 原始 base64 图片，按 PNG、JPEG、GIF、WebP 的文件头识别，长度过短的不视为图片
+const rawBase64Pattern = `(?:iVBORw0KGgo|/9j/|R0lGOD|UklGR)[A-Za-z0-9+/]{64,}=*`
+
+// 完整匹配上传文件 ID 与原始 base64，用于判断图片来源
+var (
+	fileIDRe    = regexp.MustCompile(`^` + fileIDPattern + `$`)
+	rawBase64Re = regexp.MustCompile(`^` + rawBase64Pattern + `$`)
+)
+
+// 上传文件在日志中的动作
+const actionUpload = "upload"
+
+// FileObject OpenAI 格式的文件信息
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// Files 处理 OpenAI 兼容的 POST /v1/files 请求，上传图片到存储服务并返回文件 ID
+// 文件 ID 可在提示词、references、character_reference 等任何接受图片链接的位置使用
+func Files(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Message: "only POST is supported", Type: "invalid_request_error"})
+		return
+	}
+
+	maxSize, _ := fetchLimits(cfg)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+maxUploadMemory)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		log.Printf("Failed to parse multipart form: %v", err)
+		writeError(w, badRequest("invalid_form", "request must be multipart/form-data: "+err.Error()))
+		return
+	}
+
+	// 与图片链接相同的大小、尺寸与格式限制，非 PNG 图片转换为 PNG
+	converted, err := readFormFile(r, "file", true, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	filename := r.MultipartForm.File["file"][0].Filename
+
+	created := time.Now()
+	entry := logs.ImageLog{
+		ID:     logs.NewID(),
+		Action: actionUpload,
+		Prompt: filename,
+		UserIP: r.RemoteAddr,
+	}
+	name := fmt.Sprintf("upload_%d_%s.png", created.Unix(), entry.ID)
+	response, err := upload.UploadFile(converted, name, cfg)
+	if err != nil {
+		log.Printf("文件上传失败: %v", err)
+		entry.Status = "failed"
+		entry.Error = fmt.Sprintf("上传失败: %v", err)
+		logs.LogImage(entry)
+		writeError(w, fmt.Errorf("failed to store file: %v", err))
+		return
+	}
+	entry.ImageURL = response.Data.URL
+	entry.Status = "success"
+	if err := logs.LogImage(entry); err != nil {
+		writeError(w, fmt.Errorf("failed to record file: %v", err))
+		return
+	}
+	log.Printf("File uploaded: %s -> %s", fileIDPrefix+entry.ID, entry.ImageURL)
+
+	purpose := r.FormValue("purpose")
+	if purpose == "" {
+		purpose = "vision"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(FileObject{
+		ID:        fileIDPrefix + entry.ID,
+		Object:    "file",
+		Bytes:     len(converted),
+		CreatedAt: created.Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+	}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
@@ -1,18 +1,21 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"novel-api/config"
+	"novel-api/logs"
 	"novel-api/models"
-	"regexp"
 	"strings"
 	"time"
 )
 
+// maxGenerationN 单次请求允许生成的最大图片数量 (NovelAI n_samples 上限)
+const maxGenerationN = 8
+
 // GenerationRequest 定义 OpenAI DALL-E 格式的请求结构体
 type GenerationRequest struct {
 	Model   string `json:"model"`
@@ -20,12 +23,35 @@ type GenerationRequest struct {
 	N       int    `json:"n,omitempty"`       // 生成图片数量，默认为1
 	Size    string `json:"size,omitempty"`    // 图片尺寸，如 "1024x1024"
 	Quality string `json:"quality,omitempty"` // 图片质量，如 "standard" 或 "hd"
+	// 返回格式，"url"（默认，上传到存储服务）或 "b64_json"（直接返回 base64，不经过存储）
+	ResponseFormat string `json:"response_format,omitempty"`
+	// 翻译模式：auto（默认，已是英文标签时不翻译）、always 或 never
+	Translate string `json:"translate,omitempty"`
+	// NovelAI 参数覆盖，合并到配置文件的默认值之上
+	NAIParameters *NAIParameters `json:"nai_parameters,omitempty"`
+	// 多角色提示词，仅 nai-diffusion-4 / 4-5 系列模型支持
+	Characters []CharacterRequest `json:"characters,omitempty"`
+	// 角色参考，仅 nai-diffusion-4-5 系列模型支持
+	CharacterReference *CharacterReferenceRequest `json:"character_reference,omitempty"`
+	// 参考图（vibe transfer），每张图可单独设置强度与信息提取量
+	References []ReferenceRequest `json:"references,omitempty"`
+
+	// 以下字段由 /v1/images/edits 等接口填充，不从 JSON 读取
+	action   string  // img2img 或 infill，为空时为普通生成
+	image    []byte  // 源图像
+	mask     []byte  // 重绘蒙版
+	strength float64 // 重绘强度
+	noise    float64 // 额外噪声
+
+	info  models.ModelInfo   // 由 validateGenerationRequest 解析的模型注册信息
+	alias *config.ModelAlias // 使用别名时的参数预设
 }
 
 // GenerationResponse 定义 OpenAI DALL-E 格式的响应结构体
 type GenerationResponse struct {
-	Created int64                 `json:"created"`
-	Data    []GenerationImageData `json:"data"`
+	Created int64                  `json:"created"`
+	Data    []GenerationImageData  `json:"data"`
+	Usage   map[string]interface{} `json:"usage,omitempty"`
 }
 
 // GenerationImageData 定义生成的图片数据结构
@@ -33,17 +59,12 @@ type GenerationImageData struct {
 	URL           string `json:"url,omitempty"`
 	B64JSON       string `json:"b64_json,omitempty"`
 	RevisedPrompt string `json:"revised_prompt,omitempty"`
-}
-
-// 提取链接的函数 (复用自 completions)
-func extractLinksFromPrompt(prompt string) []string {
-	re := regexp.MustCompile(`https?://[^\s]+`)
-	matches := re.FindAllString(prompt, -1)
-	return matches
+	Seed          *int   `json:"seed,omitempty"` // 种子 0 同样有效，非生成结果为 nil
 }
 
 // Generations 处理 OpenAI DALL-E 格式的画图请求
-func Generations(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+// /v1/images/generations 附带 usage 字段（includeUsage），/v1/images/generations/json 返回标准响应，其余流程完全相同
+func Generations(w http.ResponseWriter, r *http.Request, cfg *config.Config, includeUsage bool) {
 	// 设置 CORS 头
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
@@ -59,163 +80,242 @@ func Generations(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
 	authHeader := r.Header.Get("Authorization")
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
 
-	fmt.Println("Generations API 秘钥打印：", authHeader)
-
 	// 2. 解析请求体
+	req, err := decodeGenerationRequest(r, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 3. 调用生成流程
+	response, err := runGeneration(r, req, authHeader, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 4. 附带 usage 字段，兼容 new-api 等按量计费的上游
+	if includeUsage {
+		response.Usage = map[string]interface{}{
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+			"total_tokens":      16384,
+			"prompt_tokens_details": map[string]interface{}{
+				"cached_tokens_details": map[string]interface{}{},
+			},
+			"completion_tokens_details": map[string]interface{}{},
+			"output_tokens":             16384,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// decodeGenerationRequest 解析并校验画图请求，填充默认值
+func decodeGenerationRequest(r *http.Request, cfg *config.Config) (GenerationRequest, error) {
 	var req GenerationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Failed to decode generation request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return req, badRequest("invalid_json", err.Error())
 	}
 
-	log.Printf("Generation request: Model=%s, Prompt=%s", req.Model, req.Prompt)
+	log.Printf("Generation request: Model=%s, Prompt=%s", req.Model, truncate(req.Prompt, maxLoggedPrompt))
+
+	if err := validateGenerationRequest(&req, cfg); err != nil {
+		return req, err
+	}
+	return req, nil
+}
 
-	// 3. 处理默认值
+// validateGenerationRequest 校验画图请求的公共字段并填充默认值
+func validateGenerationRequest(req *GenerationRequest, cfg *config.Config) error {
+	// 解析模型别名，未注册的模型返回 404 model_not_found
+	info, alias, err := resolveModel(req.Model, cfg)
+	if err != nil {
+		return err
+	}
+	req.info = info
+	req.alias = alias
+	req.Model = info.ID
+
+	// 图生图类请求允许空提示词
+	if strings.TrimSpace(req.Prompt) == "" && req.image == nil {
+		return badRequest("invalid_prompt", "prompt is required")
+	}
+
+	// 未指定 n 时使用配置文件中的 n_samples
 	if req.N == 0 {
+		req.N = cfg.Parameters.NSamples
+	}
+	if req.N < 1 {
 		req.N = 1 // 默认生成1张图片
 	}
+	if req.N > maxGenerationN {
+		return badRequest("invalid_n", fmt.Sprintf("n must be between 1 and %d", maxGenerationN))
+	}
 
-	// 4. 获取用户输入的提示词
-	userInput := req.Prompt
+	if err := normalizeResponseFormat(&req.ResponseFormat); err != nil {
+		return err
+	}
 
-	// 5. 如果启用翻译，则翻译用户输入
-	log.Printf("[Generations] Translation.Enable value: %v (URL: %s, Model: %s)", cfg.Translation.Enable, cfg.Translation.URL, cfg.Translation.Model)
-	if cfg.Translation.Enable {
-		translatedInput, err := TranslateText(userInput, cfg)
-		if err != nil {
-			log.Printf("Translation failed, using original text: %v", err)
-		} else {
-			log.Printf("Translation enabled, translated: %s -> %s", userInput, translatedInput)
-			userInput = translatedInput
-		}
-	} else {
-		log.Printf("[Generations] Translation is disabled, skipping translation")
+	if err := normalizeTranslateMode(&req.Translate); err != nil {
+		return err
+	}
+
+	if err := req.NAIParameters.validate(); err != nil {
+		return err
+	}
+
+	// SMEA 只对 V3 模型生效，V4 的 payload 中不应出现 sm / sm_dyn
+	if p := req.NAIParameters; p != nil && req.info.Family == models.FamilyV4 && (p.SM != nil || p.SMDyn != nil) {
+		return badRequest("unsupported_parameter", fmt.Sprintf("nai_parameters.sm and nai_parameters.sm_dyn are only supported by V3 models, not %q", req.Model))
 	}
 
-	// 6. 提取用户输入中的链接 (用于参考图像)
-	imageURL := extractLinksFromPrompt(userInput)
-	var base64String string
-	if len(imageURL) > 0 {
-		// 选择第一个提取到的链接
-		imageURLS := imageURL[0]
-		// 解析图片为base64
-		base64String, _ = ImageURLToBase64(imageURLS)
+	return nil
+}
+
+// runGeneration 执行完整的画图流程：翻译、参考图、生成、上传与日志
+func runGeneration(r *http.Request, req GenerationRequest, authHeader string, cfg *config.Config) (*GenerationResponse, error) {
+	// 1. 获取用户输入的提示词，图片（链接、data URI、原始 base64、上传文件 ID）在翻译前取出
+	prompt, err := preprocessPrompt(req.Prompt, false, cfg)
+	if err != nil {
+		return nil, err
 	}
+	userInput := prompt.Text
 
-	// 7. 解析 size 参数，如果没有传递则使用配置文件中的默认值
-	width := cfg.Parameters.Width
-	height := cfg.Parameters.Height
+	// 2. 按翻译模式翻译用户输入
+	userInput = translatePrompt(userInput, req.Translate, cfg)
+
+	// 3. 解析 size 参数，如果没有传递则使用别名预设或模型的默认尺寸
+	width, height := aliasSize(req.alias, models.ModelDefaults(req.Model, cfg))
 	if req.Size != "" {
 		// 解析 size 参数，格式如 "1024x1024"
 		var parsedWidth, parsedHeight int
 		_, err := fmt.Sscanf(req.Size, "%dx%d", &parsedWidth, &parsedHeight)
-		if err == nil && parsedWidth > 0 && parsedHeight > 0 {
-			width = parsedWidth
-			height = parsedHeight
-			log.Printf("Using size from request: %dx%d", width, height)
-		} else {
-			log.Printf("Invalid size format '%s', using default: %dx%d", req.Size, width, height)
+		if err != nil || parsedWidth <= 0 || parsedHeight <= 0 {
+			return nil, badRequest("invalid_size", fmt.Sprintf("invalid size %q, expected format WIDTHxHEIGHT", req.Size))
 		}
+		width = parsedWidth
+		height = parsedHeight
+		log.Printf("Using size from request: %dx%d", width, height)
 	} else {
 		log.Printf("No size specified, using default: %dx%d", width, height)
 	}
 
-	// 8. 生成一个随机种子
-	rand.Seed(time.Now().UnixNano())
-	randomSeed := rand.Intn(1000000)
-
-	// 9. 构建兼容的 ChatRequest 结构 (复用现有模型)
-	compatibleReq := config.ChatRequest{
-		Authorization: authHeader,
-		Model:         req.Model,
-		Messages: []config.Message{
-			{
-				Role:    "user",
-				Content: req.Prompt,
-			},
-		},
-	}
-
-	// 10. 根据模型来调用相应的生成函数 (DALL-E 格式)
-	// 标识这是 DALL-E 格式请求
-	isDallRequest := true
-
-	switch req.Model {
-	case "nai-diffusion-3":
-		models.Nai3WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, width, height, isDallRequest)
-	case "nai-diffusion-furry-3":
-		models.Nai3WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, width, height, isDallRequest)
-	case "nai-diffusion-4-full":
-		models.Nai4WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, nil, width, height, isDallRequest)
-	case "nai-diffusion-4-curated-preview":
-		models.Nai4WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, nil, width, height, isDallRequest)
-	case "nai-diffusion-4-5-curated":
-		models.Nai4WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, nil, width, height, isDallRequest)
-	case "nai-diffusion-4-5-full":
-		models.Nai4WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, nil, width, height, isDallRequest)
-	default:
-		// 对于不识别的模型，尝试使用默认的 NAI-3 模型
-		log.Printf("Unknown model '%s', falling back to nai-diffusion-3", req.Model)
-		compatibleReq.Model = "nai-diffusion-3"
-		models.Nai3WithFormatAndSize(w, r, compatibleReq, randomSeed, base64String, authHeader, cfg, userInput, width, height, isDallRequest)
+	// 4. 参考图：依次为请求中的 references、提示词中的图片（可附带 |强度|信息提取量）
+	refs := append(append([]ReferenceRequest{}, req.References...), prompt.References...)
+	references, err := resolveReferences(refs, req.info, width, height, cfg)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// GenerationsJSON 处理 OpenAI DALL-E 格式的画图请求并返回 JSON 响应 (非流式)
-func GenerationsJSON(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
-	// 设置 CORS 头
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-	// 如果是 OPTIONS 请求，直接返回 200 OK
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
+	// 5. 图生图 / 局部重绘：源图像与蒙版缩放到目标尺寸
+	var sourceImage, maskImage string
+	if req.image != nil {
+		resized, err := resizeToPNG(req.image, width, height)
+		if err != nil {
+			return nil, badRequest("invalid_image", err.Error())
+		}
+		sourceImage = base64.StdEncoding.EncodeToString(resized)
+	}
+	if req.mask != nil {
+		mask, err := maskToNovelAI(req.mask, width, height)
+		if err != nil {
+			return nil, badRequest("invalid_mask", err.Error())
+		}
+		maskImage = base64.StdEncoding.EncodeToString(mask)
 	}
 
-	// 1. 获取 Authorization 请求头的值
-	authHeader := r.Header.Get("Authorization")
-	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	// 6. 角色参考与多角色：校验模型能力并缩放参考图到 NovelAI 要求的尺寸
+	if req.action == models.ActionImg2Img && !req.info.Img2Img {
+		return nil, badRequest("unsupported_action", fmt.Sprintf("model %q does not support img2img", req.Model))
+	}
+	if req.action == models.ActionInfill && req.info.InpaintingModel == "" {
+		return nil, badRequest("unsupported_action", fmt.Sprintf("model %q does not support inpainting", req.Model))
+	}
+	var characterReference *models.CharacterReference
+	if req.CharacterReference != nil {
+		ref, err := req.CharacterReference.resolve(req.info, cfg)
+		if err != nil {
+			return nil, err
+		}
+		characterReference = ref
+	}
 
-	fmt.Println("GenerationsJSON API 秘钥打印：", authHeader)
+	// 多角色提示词：按位置生成各角色
+	characters, err := resolveCharacters(req.Characters, req.info, req.Translate, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// 2. 解析请求体
-	var req GenerationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Failed to decode generation JSON request body: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	// 7. 使用请求中的种子，未指定时随机生成
+	seed := newSeed()
+	if req.NAIParameters != nil && req.NAIParameters.Seed != nil {
+		seed = int(*req.NAIParameters.Seed)
 	}
+	log.Printf("Using seed: %d", seed)
 
-	log.Printf("Generation JSON request: Model=%s, Prompt=%s", req.Model, req.Prompt)
+	gen := models.GenerateRequest{
+		Model:      req.Model,
+		Prompt:     userInput,
+		Seed:       seed,
+		Width:      width,
+		Height:     height,
+		NSamples:   req.N,
+		References: references,
+		Action:     req.action,
+		Image:      sourceImage,
+		Mask:       maskImage,
+		Strength:   req.strength,
+		Noise:      req.noise,
 
-	// 3. 处理默认值
-	if req.N == 0 {
-		req.N = 1 // 默认生成1张图片
+		CharacterPrompts:   characters,
+		CharacterReference: characterReference,
+		Overrides:          aliasOverrides(req.alias).Merge(req.NAIParameters.overrides()),
 	}
 
-	// 4. 构建响应结构
-	response := GenerationResponse{
-		Created: time.Now().Unix(),
-		Data: []GenerationImageData{
-			{
-				URL:           "", // 这里会在实际生成后填充
-				RevisedPrompt: req.Prompt,
-			},
-		},
+	// 8. 根据模型族调用相应的生成函数
+	result, err := req.info.Generator()(gen, authHeader, cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	// 5. 设置响应头并返回 JSON
-	w.Header().Set("Content-Type", "application/json")
+	// 9. 上传图片并记录日志
+	action := req.action
+	if action == "" {
+		action = models.ActionGenerate
+	}
+	stored := storeImages(r, logs.ImageLog{Model: gen.Model, Action: action, Prompt: userInput}, result, req.ResponseFormat, cfg)
 
-	// 注意：这里是一个简化版本，实际应该调用相应的模型生成图片
-	// 然后获取生成的图片URL填充到响应中
-	// 为了保持一致性，建议使用流式响应版本 Generations 函数
+	// 10. 构建响应结构
+	data, err := toImageData(stored, userInput)
+	if err != nil {
+		return nil, err
+	}
+	return &GenerationResponse{Created: time.Now().Unix(), Data: data}, nil
+}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Failed to encode JSON response: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+// toImageData 将图片结果转换为 DALL-E 响应数据，任一图片上传失败时返回错误
+func toImageData(stored []storedImage, revisedPrompt string) ([]GenerationImageData, error) {
+	data := make([]GenerationImageData, 0, len(stored))
+	for _, img := range stored {
+		if img.Err != nil {
+			return nil, &APIError{
+				Status:  http.StatusBadGateway,
+				Message: fmt.Sprintf("上传失败 - %s: %v", img.Name, img.Err),
+				Type:    "server_error",
+				Code:    "upload_failed",
+			}
+		}
+		data = append(data, GenerationImageData{
+			URL:           img.URL,
+			B64JSON:       img.B64JSON,
+			RevisedPrompt: revisedPrompt,
+			Seed:          img.Seed,
+		})
 	}
+	return data, nil
 }
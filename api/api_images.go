@@ -1,32 +1,275 @@
 package api
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
 	"net/http"
+	"novel-api/config"
+	"novel-api/logs"
+	"novel-api/models"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
-func ImageURLToBase64(imageURL string) (string, error) {
-	// 发送HTTP GET请求
-	resp, err := http.Get(imageURL)
+// decodeDataURI 解析 data:image/...;base64,... 格式的图片
+func decodeDataURI(uri string) ([]byte, error) {
+	comma := strings.IndexByte(uri, ',')
+	if !strings.HasPrefix(uri, "data:") || comma < 0 {
+		return nil, fmt.Errorf("invalid data URI")
+	}
+	if !strings.HasSuffix(uri[:comma], ";base64") {
+		return nil, fmt.Errorf("data URI must be base64 encoded")
+	}
+	return base64.StdEncoding.DecodeString(uri[comma+1:])
+}
+
+// loadImage 读取图片来源：http(s) 链接、data URI、原始 base64、上传文件 ID 或日志ID，返回 PNG 图片数据及其对应的日志（日志ID 与上传文件 ID）
+// 链接对应的日志需扫描全部日志，由需要关联来源日志的接口通过 imageSourceLog 查找
+// 所有来源都受 image_fetch.max_size 与 max_pixels 限制，JPEG、GIF、WebP 会转换为 PNG
+func loadImage(source string, cfg *config.Config) ([]byte, *logs.ImageLog, error) {
+	source = strings.TrimSpace(source)
+	var data []byte
+	var entry *logs.ImageLog
+	switch {
+	case strings.HasPrefix(source, "data:"), rawBase64Re.MatchString(source):
+		var decoded []byte
+		var err error
+		if strings.HasPrefix(source, "data:") {
+			decoded, err = decodeDataURI(source)
+		} else {
+			decoded, err = base64.StdEncoding.DecodeString(source)
+		}
+		if err != nil {
+			return nil, nil, badRequest("invalid_image", err.Error())
+		}
+		if maxSize, _ := fetchLimits(cfg); int64(len(decoded)) > maxSize {
+			return nil, nil, badRequest("invalid_image", fmt.Sprintf("image is larger than %d bytes", maxSize))
+		}
+		data = decoded
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		fetched, err := fetchImage(source, cfg)
+		if err != nil {
+			return nil, nil, fetchError(source, err)
+		}
+		data = fetched
+	default:
+		// 上传文件 ID 即 file- 前缀加日志ID
+		if fileIDRe.MatchString(source) {
+			found, err := logs.GetLogByID(strings.TrimPrefix(source, fileIDPrefix))
+			if err != nil || found == nil {
+				return nil, nil, &APIError{Status: http.StatusNotFound, Message: fmt.Sprintf("file %q not found", source), Type: "invalid_request_error", Code: "file_not_found"}
+			}
+			source = found.ID
+		}
+		found, err := logs.GetLogByID(source)
+		if err != nil || found == nil {
+			return nil, nil, &APIError{Status: http.StatusNotFound, Message: fmt.Sprintf("image log %q not found", source), Type: "invalid_request_error", Code: "log_not_found"}
+		}
+		if found.ImageURL == "" {
+			return nil, nil, badRequest("invalid_image", fmt.Sprintf("image log %q has no image url", source))
+		}
+		fetched, err := fetchImage(found.ImageURL, cfg)
+		if err != nil {
+			return nil, nil, fetchError(found.ImageURL, err)
+		}
+		data, entry = fetched, found
+	}
+
+	if err := checkImagePixels(data, cfg); err != nil {
+		return nil, nil, badRequest("invalid_image", err.Error())
+	}
+	converted, err := toPNG(data)
 	if err != nil {
-		return "", err
+		return nil, nil, badRequest("invalid_image", err.Error())
 	}
-	defer resp.Body.Close()
+	return converted, entry, nil
+}
 
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch image: %s", resp.Status)
+// imageSourceLog 返回图片来源对应的日志，本服务生成图片的链接按链接查找日志，只在放大与增强等需要关联来源日志的接口中调用
+func imageSourceLog(source string, entry *logs.ImageLog) *logs.ImageLog {
+	source = strings.TrimSpace(source)
+	if entry == nil && (strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) {
+		entry, _ = logs.GetLogByImageURL(source)
 	}
+	return entry
+}
+
+// fetchError 将下载失败转换为 400 错误，消息中包含图片地址与原因
+func fetchError(imageURL string, err error) error {
+	log.Printf("Failed to fetch image %s: %v", imageURL, err)
+	return badRequest("image_fetch_failed", fmt.Sprintf("failed to fetch image %s: %v", imageURL, err))
+}
 
-	// 读取响应体
-	fileData, err := ioutil.ReadAll(resp.Body)
+// toPNG 校验图片格式，JPEG、GIF（取第一帧）、WebP 转换为 PNG
+func toPNG(data []byte) ([]byte, error) {
+	if err := checkImageType(data); err != nil {
+		return nil, err
+	}
+	if http.DetectContentType(data) == "image/png" {
+		return data, nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("无法解码图像: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("PNG 编码失败: %v", err)
 	}
+	log.Printf("Converted %s image to PNG: %d -> %d bytes", format, len(data), buf.Len())
+	return buf.Bytes(), nil
+}
+
+// fitCanvas 将图像等比缩小到目标画布以内，不超过画布时原样返回
+func fitCanvas(data []byte, width, height int) ([]byte, error) {
+	srcW, srcH, err := imageSize(data)
+	if err != nil {
+		return nil, err
+	}
+	if srcW <= width && srcH <= height {
+		return data, nil
+	}
+	scale := float64(width) / float64(srcW)
+	if s := float64(height) / float64(srcH); s < scale {
+		scale = s
+	}
+	fitW, fitH := max(1, int(float64(srcW)*scale)), max(1, int(float64(srcH)*scale))
+	return resizeToPNG(data, fitW, fitH)
+}
+
+// snapSize 将尺寸对齐到 NovelAI 要求的 64 的倍数
+func snapSize(width, height int) (int, int) {
+	snap := func(v int) int {
+		v = (v + 32) / 64 * 64
+		if v < 64 {
+			v = 64
+		}
+		return v
+	}
+	return snap(width), snap(height)
+}
+
+// imageSize 读取图像尺寸
+func imageSize(data []byte) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("无法识别的图像格式: %v", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
 
-	// 将图像数据编码为Base64
-	encodedString := base64.StdEncoding.EncodeToString(fileData)
-	return encodedString, nil
+// resizeToPNG 将图像缩放到指定尺寸并编码为 PNG
+func resizeToPNG(data []byte, width, height int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法解码图像: %v", err)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if src.Bounds().Dx() == width && src.Bounds().Dy() == height {
+		draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("PNG 编码失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// maskToNovelAI 将蒙版转换为 NovelAI 格式（白色为重绘区域）并缩放到指定尺寸
+// 带透明通道的蒙版按 OpenAI 约定处理：透明区域为重绘区域；否则按亮度处理：白色为重绘区域
+func maskToNovelAI(data []byte, width, height int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法解码蒙版: %v", err)
+	}
+
+	bounds := src.Bounds()
+	hasAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y && !hasAlpha; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := src.At(x, y).RGBA(); a < 0xffff {
+				hasAlpha = true
+				break
+			}
+		}
+	}
+
+	mask := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := src.At(x, y)
+			paint := false
+			if hasAlpha {
+				_, _, _, a := c.RGBA()
+				paint = a < 0x8000
+			} else {
+				paint = color.GrayModel.Convert(c).(color.Gray).Y >= 128
+			}
+			if paint {
+				mask.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	draw.NearestNeighbor.Scale(dst, dst.Bounds(), mask, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("PNG 编码失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fitCharacterReference 按宽高比选择 NovelAI 接受的角色参考尺寸，等比缩放后居中填充黑边
+func fitCharacterReference(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("无法解码图像: %v", err)
+	}
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// 选择宽高比最接近的目标尺寸
+	target := models.CharacterReferenceSizes[0]
+	bestDiff := -1.0
+	for _, size := range models.CharacterReferenceSizes {
+		diff := float64(srcW)/float64(srcH) - float64(size[0])/float64(size[1])
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || diff < bestDiff {
+			target, bestDiff = size, diff
+		}
+	}
+
+	// 等比缩放到目标尺寸内
+	scale := float64(target[0]) / float64(srcW)
+	if s := float64(target[1]) / float64(srcH); s < scale {
+		scale = s
+	}
+	fitW, fitH := int(float64(srcW)*scale), int(float64(srcH)*scale)
+	offsetX, offsetY := (target[0]-fitW)/2, (target[1]-fitH)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, target[0], target[1]))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, image.Rect(offsetX, offsetY, offsetX+fitW, offsetY+fitH), src, src.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("PNG 编码失败: %v", err)
+	}
+	return buf.Bytes(), nil
 }
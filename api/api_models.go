@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/models"
+	"sort"
+	"strings"
+)
+
+// ModelObject OpenAI 格式的模型信息
+type ModelObject struct {
+	ID           string            `json:"id"`
+	Object       string            `json:"object"`
+	Created      int64             `json:"created"`
+	OwnedBy      string            `json:"owned_by"`
+	Name         string            `json:"name,omitempty"`
+	Root         string            `json:"root,omitempty"` // 别名对应的实际模型
+	Capabilities ModelCapabilities `json:"capabilities"`
+}
+
+// ModelCapabilities 模型支持的功能
+type ModelCapabilities struct {
+	Img2Img            bool `json:"img2img"`
+	Inpainting         bool `json:"inpainting"`
+	Vibes              bool `json:"vibes"`
+	Characters         bool `json:"characters"`
+	CharacterReference bool `json:"character_reference"`
+}
+
+// ModelList OpenAI 格式的模型列表
+type ModelList struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+// Models 处理 GET /v1/models 与 GET /v1/models/{id}
+func Models(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var response interface{}
+	if id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/"); id != "" {
+		info, alias, err := resolveModel(id, cfg)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		response = modelObject(info, id, alias != nil)
+	} else {
+		list := ModelList{Object: "list", Data: []ModelObject{}}
+		for _, info := range models.Models() {
+			list.Data = append(list.Data, modelObject(info, info.ID, false))
+		}
+
+		// 别名排在实际模型之后，按名称排序
+		aliases := make([]string, 0, len(cfg.ModelAliases))
+		for name := range cfg.ModelAliases {
+			aliases = append(aliases, name)
+		}
+		sort.Strings(aliases)
+		for _, name := range aliases {
+			info, _, err := resolveModel(name, cfg)
+			if err != nil {
+				log.Printf("Skipping model alias %q: %v", name, err)
+				continue
+			}
+			list.Data = append(list.Data, modelObject(info, name, true))
+		}
+		response = list
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// modelObject 将注册信息转换为 OpenAI 格式，id 为别名时附带实际模型
+func modelObject(info models.ModelInfo, id string, isAlias bool) ModelObject {
+	object := ModelObject{
+		ID:      id,
+		Object:  "model",
+		OwnedBy: "novelai",
+		Name:    info.Name,
+		Capabilities: ModelCapabilities{
+			Img2Img:            info.Img2Img,
+			Inpainting:         info.InpaintingModel != "",
+			Vibes:              info.Vibes,
+			Characters:         info.Characters,
+			CharacterReference: info.CharacterReference,
+		},
+	}
+	if isAlias {
+		object.Root = info.ID
+	}
+	return object
+}
+
+// resolveModel 查找模型并解析别名，未指定时使用默认模型，未注册时返回 404 model_not_found
+// 使用别名时同时返回别名的参数预设
+func resolveModel(model string, cfg *config.Config) (models.ModelInfo, *config.ModelAlias, error) {
+	if strings.TrimSpace(model) == "" {
+		model = models.DefaultModel
+	}
+
+	var alias *config.ModelAlias
+	if preset, ok := cfg.ModelAliases[model]; ok {
+		log.Printf("Model alias %s -> %s", model, preset.Model)
+		if err := validateAlias(model, &preset); err != nil {
+			return models.ModelInfo{}, nil, err
+		}
+		alias = &preset
+		model = preset.Model
+	}
+
+	info, ok := models.LookupModel(model)
+	if !ok {
+		return info, nil, &APIError{
+			Status:  http.StatusNotFound,
+			Message: fmt.Sprintf("The model `%s` does not exist", model),
+			Type:    "invalid_request_error",
+			Code:    "model_not_found",
+		}
+	}
+	return info, alias, nil
+}
+
+// validateAlias 校验别名的参数预设，范围与单次请求的参数覆盖相同，尺寸需同时设置且为 64 的倍数
+// 预设有误属于配置错误，返回 500 invalid_model_alias，消息中包含别名
+func validateAlias(name string, alias *config.ModelAlias) error {
+	err := validateOverrides(aliasOverrides(alias))
+	if err == nil && (alias.Width != 0 || alias.Height != 0) {
+		if width, height := snapSize(alias.Width, alias.Height); alias.Width <= 0 || alias.Height <= 0 {
+			err = fmt.Errorf("width and height must both be set")
+		} else if width != alias.Width || height != alias.Height {
+			err = fmt.Errorf("width and height must be multiples of 64, e.g. %dx%d", width, height)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	log.Printf("Invalid model alias %q: %v", name, err)
+	return &APIError{
+		Status:  http.StatusInternalServerError,
+		Message: fmt.Sprintf("model alias %q is misconfigured: %v", name, err),
+		Type:    "server_error",
+		Code:    "invalid_model_alias",
+	}
+}
+
+// aliasOverrides 将别名的参数预设转换为模型层的参数覆盖
+func aliasOverrides(alias *config.ModelAlias) *models.Overrides {
+	if alias == nil {
+		return nil
+	}
+	return &models.Overrides{
+		Steps:          alias.Steps,
+		Scale:          alias.Scale,
+		Sampler:        alias.Sampler,
+		NoiseSchedule:  alias.NoiseSchedule,
+		CFGRescale:     alias.CFGRescale,
+		NegativePrompt: alias.NegativePrompt,
+		Variety:        alias.Variety,
+	}
+}
+
+// aliasSize 返回别名预设的尺寸，未设置时返回模型默认尺寸
+func aliasSize(alias *config.ModelAlias, defaults models.Defaults) (int, int) {
+	if alias != nil && alias.Width > 0 && alias.Height > 0 {
+		return alias.Width, alias.Height
+	}
+	return defaults.Width, defaults.Height
+}
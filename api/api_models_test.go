@@ -0,0 +1,36 @@
+package api
+
+import (
+	"novel-api/config"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlias(t *testing.T) {
+	steps, badSteps := 28, 100
+	sampler, badSampler := "k_euler", "euler_magic"
+	tests := []struct {
+		name  string
+		alias config.ModelAlias
+		want  string // 错误消息中应包含的内容，为空表示校验通过
+	}{
+		{"plain", config.ModelAlias{Model: "nai-diffusion-4-5-full"}, ""},
+		{"preset", config.ModelAlias{Model: "nai-diffusion-4-5-full", Width: 1024, Height: 1024, Steps: &steps, Sampler: &sampler}, ""},
+		{"steps", config.ModelAlias{Model: "nai-diffusion-4-5-full", Steps: &badSteps}, "steps must be between"},
+		{"sampler", config.ModelAlias{Model: "nai-diffusion-4-5-full", Sampler: &badSampler}, "sampler must be one of"},
+		{"size", config.ModelAlias{Model: "nai-diffusion-4-5-full", Width: 1000, Height: 1000}, "multiples of 64, e.g. 1024x1024"},
+		{"width-only", config.ModelAlias{Model: "nai-diffusion-4-5-full", Width: 1024}, "must both be set"},
+	}
+	for _, tt := range tests {
+		err := validateAlias(tt.name, &tt.alias)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("validateAlias(%q) error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), `"`+tt.name+`"`) {
+			t.Errorf("validateAlias(%q) = %v, want error containing %q and the alias name", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/logs"
+	"novel-api/models"
+	"novel-api/upload"
+	"time"
+)
+
+// DALL-E 格式支持的 response_format
+const (
+	ResponseFormatURL     = "url"
+	ResponseFormatB64JSON = "b64_json"
+)
+
+// normalizeResponseFormat 校验 response_format，未指定时默认为 url
+func normalizeResponseFormat(format *string) error {
+	if *format == "" {
+		*format = ResponseFormatURL
+	}
+	if *format != ResponseFormatURL && *format != ResponseFormatB64JSON {
+		return badRequest("invalid_response_format", fmt.Sprintf("response_format must be %q or %q", ResponseFormatURL, ResponseFormatB64JSON))
+	}
+	return nil
+}
+
+// storedImage 单张生成图片的输出结果
+type storedImage struct {
+	Name    string // 文件名
+	URL     string // 上传后的链接（url 格式）
+	B64JSON string // base64 图像（b64_json 格式）
+	Seed    *int   // 实际使用的种子，放大、增强等非生成结果为 nil
+	Err     error  // 上传失败时的错误
+}
+
+// storeImages 按返回格式处理生成结果：url 格式上传到存储服务，b64_json 格式直接内联；
+// 每张图片都以 entry 为模板记录一条日志
+func storeImages(r *http.Request, entry logs.ImageLog, result *models.GenerateResult, responseFormat string, cfg *config.Config) []storedImage {
+	entry.UserIP = r.RemoteAddr
+
+	timestamp := time.Now().Unix()
+	stored := make([]storedImage, 0, len(result.Images))
+
+	for i, imageData := range result.Images {
+		img := storedImage{
+			Name: fmt.Sprintf("%s%d_%d.png", result.NamePrefix, timestamp, i),
+		}
+		if i < len(result.Seeds) {
+			seed := result.Seeds[i]
+			img.Seed = &seed
+		}
+		log.Printf("图像数据读取成功，大小: %d bytes", len(imageData))
+		entry.Seed = img.Seed
+
+		// b64_json 格式直接内联返回 base64 图像，不经过存储服务
+		if responseFormat == ResponseFormatB64JSON {
+			img.B64JSON = base64.StdEncoding.EncodeToString(imageData)
+
+			// 记录成功日志（未上传，无图片链接）
+			success := entry
+			success.Status = "success"
+			logs.LogImage(success)
+			stored = append(stored, img)
+			continue
+		}
+
+		// 调用通用上传函数
+		log.Printf("开始上传图片: %s", img.Name)
+		response, err := upload.UploadFile(imageData, img.Name, cfg)
+		if err != nil {
+			log.Printf("图片上传失败: %v", err)
+			img.Err = err
+
+			// 记录失败日志
+			failed := entry
+			failed.Status = "failed"
+			failed.Error = fmt.Sprintf("上传失败: %v", err)
+			logs.LogImage(failed)
+		} else {
+			log.Printf("图片上传成功: %s", response.Data.URL)
+			img.URL = response.Data.URL
+
+			// 记录成功日志
+			success := entry
+			success.ImageURL = img.URL
+			success.Status = "success"
+			logs.LogImage(success)
+		}
+		stored = append(stored, img)
+	}
+
+	return stored
+}
+
+// markdownLinks 将图片结果转换为聊天使用的 Markdown 图片链接
+func markdownLinks(stored []storedImage) []string {
+	links := make([]string, 0, len(stored))
+	for _, img := range stored {
+		outputs := img.URL
+		if img.Err != nil {
+			outputs = fmt.Sprintf("error: 上传失败 - %s", img.Name) // 如果上传失败，返回错误信息
+		}
+		publicLink := fmt.Sprintf("![%s](%s)", img.Name, outputs)
+		if img.Seed != nil {
+			publicLink += fmt.Sprintf("\nseed: `%d`", *img.Seed)
+		}
+		fmt.Println(publicLink)
+		links = append(links, publicLink)
+	}
+	return links
+}
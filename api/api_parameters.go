@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"novel-api/models"
+	"strings"
+)
+
+// NAIParameters 单次请求覆盖的 NovelAI 参数，未传的字段使用配置文件中的值
+type NAIParameters struct {
+	Seed           *int64   `json:"seed,omitempty"`            // 随机种子 0-4294967295
+	Steps          *int     `json:"steps,omitempty"`           // 采样步数 1-50
+	Scale          *float64 `json:"scale,omitempty"`           // 提示词引导强度 0-10
+	Sampler        *string  `json:"sampler,omitempty"`         // 采样器
+	NoiseSchedule  *string  `json:"noise_schedule,omitempty"`  // 噪声调度
+	CFGRescale     *float64 `json:"cfg_rescale,omitempty"`     // 引导重缩放 0-1
+	NegativePrompt *string  `json:"negative_prompt,omitempty"` // 反向提示词，替换配置中的反词
+	SM             *bool    `json:"sm,omitempty"`              // SMEA，仅 V3
+	SMDyn          *bool    `json:"sm_dyn,omitempty"`          // SMEA DYN，仅 V3
+	Variety        *bool    `json:"variety,omitempty"`         // Variety+（skip_cfg_above_sigma）
+}
+
+// validate 校验各字段取值，错误信息带上 nai_parameters 前缀
+func (p *NAIParameters) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Seed != nil && (*p.Seed < 0 || *p.Seed > maxSeed) {
+		return badRequest("invalid_seed", fmt.Sprintf("nai_parameters.seed must be between 0 and %d", uint32(maxSeed)))
+	}
+	if p.Sampler != nil {
+		sampler := strings.ToLower(*p.Sampler)
+		p.Sampler = &sampler
+	}
+	if p.NoiseSchedule != nil {
+		schedule := strings.ToLower(*p.NoiseSchedule)
+		p.NoiseSchedule = &schedule
+	}
+	if err := validateOverrides(p.overrides()); err != nil {
+		apiErr := err.(*APIError)
+		apiErr.Message = "nai_parameters." + apiErr.Message
+		return apiErr
+	}
+	return nil
+}
+
+// overrides 转换为模型层的参数覆盖
+func (p *NAIParameters) overrides() *models.Overrides {
+	if p == nil {
+		return nil
+	}
+	return &models.Overrides{
+		Steps:          p.Steps,
+		Scale:          p.Scale,
+		Sampler:        p.Sampler,
+		NoiseSchedule:  p.NoiseSchedule,
+		CFGRescale:     p.CFGRescale,
+		SM:             p.SM,
+		SMDyn:          p.SMDyn,
+		Variety:        p.Variety,
+		NegativePrompt: p.NegativePrompt,
+	}
+}
+
+// newSeed 在 NovelAI 的完整 32 位种子范围内随机生成种子
+// math/rand/v2 的全局生成器无需手动播种且并发安全，不会像 rand.Seed 那样在每次请求时重置全局状态
+func newSeed() int {
+	return int(rand.Int64N(maxSeed + 1))
+}
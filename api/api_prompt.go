@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log"
+	"novel-api/config"
+)
+
+// 日志中提示词的最大长度，超出部分截断，避免 base64 与长文本刷屏
+const maxLoggedPrompt = 500
+
+// promptParts 预处理后的提示词，结构化内容已从文本中取出
+type promptParts struct {
+	Text               string                     // 剩余的场景描述，送去翻译并作为正向提示词
+	References         []ReferenceRequest         // 图片链接、data URI、原始 base64 与上传文件 ID，按出现顺序
+	Directives         *chatDirectives            // 生成指令，仅聊天接口
+	CharacterReference *CharacterReferenceRequest // --cref 角色参考，仅聊天接口
+	Characters         []CharacterRequest         // --char 多角色，仅聊天接口
+}
+
+// preprocessPrompt 在翻译前依次取出提示词中的结构化内容，避免翻译改写或丢弃链接，也避免链接进入正向提示词
+// 聊天接口依次处理 --cref、图片、生成指令与 --char；图片接口的这些参数来自 JSON 字段，只取出图片
+func preprocessPrompt(text string, chat bool, cfg *config.Config) (*promptParts, error) {
+	p := &promptParts{Directives: &chatDirectives{}}
+	logPromptStage("input", text)
+
+	var err error
+	if chat {
+		// 角色参考指令：--cref <图片>[|强度|保真度]，需在取出图片前处理，避免图片被当作 vibe 参考图
+		text, p.CharacterReference, err = extractCharacterReference(text)
+		if err != nil {
+			return nil, err
+		}
+		logPromptStage("cref", text)
+	}
+
+	// 图片：链接、data URI、原始 base64 与上传文件 ID，可附带 |强度|信息提取量
+	text, p.References, err = extractImages(text)
+	if err != nil {
+		return nil, err
+	}
+	logPromptStage("images", text)
+
+	if chat {
+		// 生成指令：--ar、--seed、--steps、--scale、--sampler、--no、--model、--strength
+		text, p.Directives, err = extractDirectives(text, cfg)
+		if err != nil {
+			return nil, err
+		}
+		logPromptStage("directives", text)
+
+		// 多角色指令：--char [位置] 角色提示词 [| 反向提示词]
+		text, p.Characters = extractCharacters(text)
+		logPromptStage("characters", text)
+	}
+
+	p.Text = text
+	return p, nil
+}
+
+// logPromptStage 记录预处理每个阶段后的文本
+func logPromptStage(stage string, text string) {
+	log.Printf("[Prompt] %-12s %s", stage+":", truncate(text, maxLoggedPrompt))
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"novel-api/config"
+	"novel-api/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CharacterReferenceRequest 角色参考请求参数（NAI 4.5）
+type CharacterReferenceRequest struct {
+	Image      string   `json:"image"`                 // 图片链接、data URI 或日志ID
+	Strength   *float64 `json:"strength,omitempty"`    // 参考强度 0-1，默认 1
+	Fidelity   *float64 `json:"fidelity,omitempty"`    // 保真度 0-1，默认 1
+	StyleAware *bool    `json:"style_aware,omitempty"` // 是否同时参考画风，默认 true
+}
+
+// ReferenceRequest 参考图（vibe transfer）请求参数
+type ReferenceRequest struct {
+	Image                string   `json:"image"`                           // 图片链接、data URI 或日志ID
+	Strength             *float64 `json:"strength,omitempty"`              // 参考强度 0-1，默认取配置 references.strength
+	InformationExtracted *float64 `json:"information_extracted,omitempty"` // 信息提取量 0-1，默认取配置 references.information_extracted
+}
+
+// 参考图未配置时的默认值
+const (
+	defaultReferenceStrength    = 0.6
+	defaultReferenceInformation = 1.0
+	defaultMaxReferences        = 4
+)
+
+// 聊天中的角色参考语法：--cref <图片链接>[|强度|保真度]
+var crefDirectiveRe = regexp.MustCompile(`(?:^|\s)--cref\s+(\S+)`)
+
+// 提示词中的图片：http(s) 链接、data URI、原始 base64（按 PNG/JPEG/GIF/WebP 文件头识别）与上传文件 ID，均可附带 |强度|信息提取量
+// 链接只匹配可见 ASCII 字符，紧跟在链接后的中文与全角标点不会被当作链接的一部分；链接末尾的逗号视为分隔符，
+// 句末标点与未配对的右括号由 trimLinkToken 放回正文
+var imageTokenRe = regexp.MustCompile(`(?:https?://[\x21-\x7b\x7d\x7e]*[\x21-\x2b\x2d-\x7b\x7d\x7e]|data:image/[a-zA-Z0-9.+-]+;base64,[A-Za-z0-9+/]+=*|` + rawBase64Pattern + `|\b` + fileIDPattern + `\b)(?:\|[\x21-\x2b\x2d-\x7b\x7d\x7e]*){0,2}`)
+
+// Markdown 图片 ![说明](链接)，取出前先还原为链接本身，避免留下 ![说明]()
+var markdownImageRe = regexp.MustCompile(`!\[[^\]\n]*\]\(((?:https?://|data:image/)[^\s)]+)\)`)
+
+// 链接末尾的句末标点，视为正文而非链接的一部分
+const linkTrailingPunct = ".,;:!?'\""
+
+// 取出图片后留下的空白、标点前的空白、空的逗号分隔段（含全角逗号）与空括号
+var (
+	blankRunRe     = regexp.MustCompile(`[ \t]{2,}`)
+	spacePunctRe   = regexp.MustCompile(`[ \t]+([,，.!?。！？])`)
+	emptySegmentRe = regexp.MustCompile(`([,，])(?:[ \t]*[,，])+`)
+	emptyBracketRe = regexp.MustCompile(`\([ \t]*\)|（[ \t]*）|\[[ \t]*\]|<[ \t]*>`)
+)
+
+// resolve 校验参数、读取并缩放参考图像
+func (c *CharacterReferenceRequest) resolve(model models.ModelInfo, cfg *config.Config) (*models.CharacterReference, error) {
+	if !model.CharacterReference {
+		return nil, badRequest("unsupported_character_reference", fmt.Sprintf("model %q does not support character reference, use a nai-diffusion-4-5 model", model.ID))
+	}
+	if strings.TrimSpace(c.Image) == "" {
+		return nil, badRequest("invalid_character_reference", "character_reference.image is required")
+	}
+
+	ref := &models.CharacterReference{Strength: 1, Fidelity: 1, StyleAware: true}
+	if c.Strength != nil {
+		if *c.Strength < 0 || *c.Strength > 1 {
+			return nil, badRequest("invalid_character_reference", "character_reference.strength must be between 0 and 1")
+		}
+		ref.Strength = *c.Strength
+	}
+	if c.Fidelity != nil {
+		if *c.Fidelity < 0 || *c.Fidelity > 1 {
+			return nil, badRequest("invalid_character_reference", "character_reference.fidelity must be between 0 and 1")
+		}
+		ref.Fidelity = *c.Fidelity
+	}
+	if c.StyleAware != nil {
+		ref.StyleAware = *c.StyleAware
+	}
+
+	data, _, err := loadImage(c.Image, cfg)
+	if err != nil {
+		return nil, err
+	}
+	fitted, err := fitCharacterReference(data)
+	if err != nil {
+		return nil, badRequest("invalid_character_reference", err.Error())
+	}
+	ref.Image = base64.StdEncoding.EncodeToString(fitted)
+	log.Printf("Character reference resolved: strength=%.2f, fidelity=%.2f", ref.Strength, ref.Fidelity)
+	return ref, nil
+}
+
+// extractCharacterReference 从聊天输入中取出 --cref 指令，返回去除指令后的文本
+func extractCharacterReference(userInput string) (string, *CharacterReferenceRequest, error) {
+	match := crefDirectiveRe.FindStringSubmatchIndex(userInput)
+	if match == nil {
+		return userInput, nil, nil
+	}
+
+	parts := strings.Split(userInput[match[2]:match[3]], "|")
+	cref := &CharacterReferenceRequest{Image: parts[0]}
+	values := []**float64{&cref.Strength, &cref.Fidelity}
+	for i, part := range parts[1:] {
+		if i >= len(values) {
+			break
+		}
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return userInput, nil, badRequest("invalid_character_reference", fmt.Sprintf("invalid --cref value %q", part))
+		}
+		*values[i] = &value
+	}
+
+	rest := blankRunRe.ReplaceAllString(userInput[:match[0]]+" "+userInput[match[1]:], " ")
+	return strings.TrimSpace(rest), cref, nil
+}
+
+// extractImages 按出现顺序取出文本中的图片，返回去除图片后的文本，避免链接与 base64 被发送给翻译与 NovelAI
+func extractImages(text string) (string, []ReferenceRequest, error) {
+	var refs []ReferenceRequest
+	var parseErr error
+	rest := markdownImageRe.ReplaceAllString(text, " $1 ")
+	rest = imageTokenRe.ReplaceAllStringFunc(rest, func(token string) string {
+		token, trailing := trimLinkToken(token)
+		ref, err := parseReference(token)
+		if err != nil && parseErr == nil {
+			parseErr = err
+		}
+		refs = append(refs, ref)
+		return trailing
+	})
+	if parseErr != nil {
+		return text, nil, parseErr
+	}
+	if len(refs) == 0 {
+		return text, nil, nil
+	}
+	log.Printf("Extracted %d images from prompt", len(refs))
+	rest = emptyBracketRe.ReplaceAllString(rest, "")
+	rest = emptySegmentRe.ReplaceAllString(rest, "$1")
+	rest = spacePunctRe.ReplaceAllString(rest, "$1")
+	rest = blankRunRe.ReplaceAllString(rest, " ")
+	return strings.TrimSpace(strings.Trim(strings.TrimSpace(rest), ",，")), refs, nil
+}
+
+// trimLinkToken 去掉图片末尾的句末标点与未配对的右括号，返回图片与需要放回正文的部分
+// 如 "(https://example.com/a.png)" 中的 ")"、"见 https://example.com/a.png." 中的 "."
+func trimLinkToken(token string) (string, string) {
+	trailing := ""
+	for token != "" {
+		last := token[len(token)-1]
+		switch {
+		case strings.IndexByte(linkTrailingPunct, last) >= 0:
+		case last == ')' && strings.Count(token, "(") < strings.Count(token, ")"):
+		case last == ']' && strings.Count(token, "[") < strings.Count(token, "]"):
+		case last == '>' && strings.Count(token, "<") < strings.Count(token, ">"):
+		default:
+			return token, trailing
+		}
+		token, trailing = token[:len(token)-1], token[len(token)-1:]+trailing
+	}
+	return token, trailing
+}
+
+// parseReference 解析 <图片>[|强度|信息提取量]
+func parseReference(token string) (ReferenceRequest, error) {
+	parts := strings.Split(token, "|")
+	ref := ReferenceRequest{Image: parts[0]}
+	values := []**float64{&ref.Strength, &ref.InformationExtracted}
+	for i, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return ref, badRequest("invalid_reference", fmt.Sprintf("invalid reference value %q after %s", part, truncate(parts[0], 64)))
+		}
+		*values[i] = &value
+	}
+	return ref, nil
+}
+
+// truncate 截断过长的文本（如 base64），用于错误消息与日志
+func truncate(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	return text[:n] + "..."
+}
+
+// resolveReferences 校验参考图数量与参数，读取图片、缩小到生成画布以内并补全默认值
+func resolveReferences(refs []ReferenceRequest, model models.ModelInfo, width, height int, cfg *config.Config) ([]models.Reference, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if !model.Vibes {
+		return nil, badRequest("unsupported_references", fmt.Sprintf("model %q does not support reference images", model.ID))
+	}
+	limit := cfg.References.Max
+	if limit <= 0 {
+		limit = defaultMaxReferences
+	}
+	if len(refs) > limit {
+		return nil, badRequest("invalid_reference", fmt.Sprintf("at most %d reference images are supported", limit))
+	}
+
+	strength := defaultReferenceStrength
+	if cfg.References.Strength != nil {
+		strength = *cfg.References.Strength
+	}
+	information := defaultReferenceInformation
+	if cfg.References.InformationExtracted != nil {
+		information = *cfg.References.InformationExtracted
+	}
+
+	resolved := make([]models.Reference, 0, len(refs))
+	for i, ref := range refs {
+		if strings.TrimSpace(ref.Image) == "" {
+			return nil, badRequest("invalid_reference", fmt.Sprintf("references[%d].image is required", i))
+		}
+		r := models.Reference{Strength: strength, InformationExtracted: information}
+		if ref.Strength != nil {
+			if *ref.Strength < 0 || *ref.Strength > 1 {
+				return nil, badRequest("invalid_reference", fmt.Sprintf("references[%d].strength must be between 0 and 1", i))
+			}
+			r.Strength = *ref.Strength
+		}
+		if ref.InformationExtracted != nil {
+			if *ref.InformationExtracted < 0 || *ref.InformationExtracted > 1 {
+				return nil, badRequest("invalid_reference", fmt.Sprintf("references[%d].information_extracted must be between 0 and 1", i))
+			}
+			r.InformationExtracted = *ref.InformationExtracted
+		}
+
+		data, _, err := loadImage(ref.Image, cfg)
+		if err != nil {
+			return nil, err
+		}
+		fitted, err := fitCanvas(data, width, height)
+		if err != nil {
+			return nil, badRequest("invalid_reference", err.Error())
+		}
+		r.Image = base64.StdEncoding.EncodeToString(fitted)
+		resolved = append(resolved, r)
+		log.Printf("Reference %d: strength=%.2f, information_extracted=%.2f", i+1, r.Strength, r.InformationExtracted)
+	}
+	return resolved, nil
+}
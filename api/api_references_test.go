@@ -0,0 +1,127 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractImages(t *testing.T) {
+	const png = "https://example.com/a.png"
+	tests := []struct {
+		text   string
+		rest   string
+		images []string
+	}{
+		{"1girl, " + png + ", smile", "1girl, smile", []string{png}},
+		{png + " 一个女孩", "一个女孩", []string{png}},
+		{"一个女孩" + png + "，微笑", "一个女孩，微笑", []string{png}},
+
+		// 括号与 Markdown
+		{"see (" + png + ") now", "see now", []string{png}},
+		{"参考 [" + png + "] 的画风", "参考 的画风", []string{png}},
+		{"![nai4_xxxx.png](" + png + ")\nseed: `42`", "seed: `42`", []string{png}},
+		{"像这样 ![图](" + png + ") 但是银发", "像这样 但是银发", []string{png}},
+		{"<" + png + ">", "", []string{png}},
+		{"https://en.wikipedia.org/wiki/Foo_(bar) style", "style", []string{"https://en.wikipedia.org/wiki/Foo_(bar)"}},
+
+		// 句末标点
+		{"like " + png + ".", "like.", []string{png}},
+		{"look at " + png + "! so cute", "look at! so cute", []string{png}},
+		{"is it " + png + "?", "is it?", []string{png}},
+
+		// 强度与信息提取量
+		{png + "|0.5|0.8, 1girl", "1girl", []string{png}},
+		{"(" + png + "|0.5)", "", []string{png}},
+
+		// 多张图片与上传文件 ID
+		{png + " https://example.com/b.jpg 两张", "两张", []string{png, "https://example.com/b.jpg"}},
+		{"file-20240101123456abc123 银发", "银发", []string{"file-20240101123456abc123"}},
+
+		// 没有图片
+		{"1girl, (smile), [bad hands]", "1girl, (smile), [bad hands]", nil},
+	}
+	for _, tt := range tests {
+		rest, refs, err := extractImages(tt.text)
+		if err != nil {
+			t.Errorf("extractImages(%q) error: %v", tt.text, err)
+			continue
+		}
+		var images []string
+		for _, ref := range refs {
+			images = append(images, ref.Image)
+		}
+		if rest != tt.rest || !reflect.DeepEqual(images, tt.images) {
+			t.Errorf("extractImages(%q) = %q, %q, want %q, %q", tt.text, rest, images, tt.rest, tt.images)
+		}
+	}
+}
+
+func TestExtractImagesReferenceValues(t *testing.T) {
+	_, refs, err := extractImages("(https://example.com/a.png|0.5|0.8).")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Strength == nil || *refs[0].Strength != 0.5 || refs[0].InformationExtracted == nil || *refs[0].InformationExtracted != 0.8 {
+		t.Fatalf("unexpected references: %+v", refs)
+	}
+
+	if _, _, err := extractImages("https://example.com/a.png|strong"); err == nil {
+		t.Error("expected error for invalid reference value")
+	}
+}
+
+func TestExtractCharacterReference(t *testing.T) {
+	tests := []struct {
+		input   string
+		rest    string
+		want    *CharacterReferenceRequest
+		wantErr bool
+	}{
+		{"一个女孩", "一个女孩", nil, false},
+		{"--cref https://example.com/a.png 一个女孩", "一个女孩", &CharacterReferenceRequest{Image: "https://example.com/a.png"}, false},
+		{"一个女孩 --cref https://example.com/a.png 在公园", "一个女孩 在公园", &CharacterReferenceRequest{Image: "https://example.com/a.png"}, false},
+		{"一个女孩 --cref file-20240101123456abc123|0.8|0.5", "一个女孩", &CharacterReferenceRequest{Image: "file-20240101123456abc123", Strength: ptr(0.8), Fidelity: ptr(0.5)}, false},
+		{"一个女孩 --cref https://example.com/a.png|0.8", "一个女孩", &CharacterReferenceRequest{Image: "https://example.com/a.png", Strength: ptr(0.8)}, false},
+
+		// 不是指令
+		{"a--cref x", "a--cref x", nil, false},
+
+		{"一个女孩 --cref https://example.com/a.png|strong", "", nil, true},
+	}
+	for _, tt := range tests {
+		rest, cref, err := extractCharacterReference(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("extractCharacterReference(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if rest != tt.rest || !reflect.DeepEqual(cref, tt.want) {
+			t.Errorf("extractCharacterReference(%q) = %q, %+v, want %q, %+v", tt.input, rest, cref, tt.rest, tt.want)
+		}
+	}
+}
+
+func TestImageTokenRe(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"https://example.com/a.png", []string{"https://example.com/a.png"}},
+		{"https://example.com/a.png|0.5|0.8", []string{"https://example.com/a.png|0.5|0.8"}},
+		{"https://example.com/a.png, 1girl", []string{"https://example.com/a.png"}},
+		{"https://example.com/a.png,", []string{"https://example.com/a.png"}},
+		{"https://example.com/a.png一个女孩，", []string{"https://example.com/a.png"}},
+		{"data:image/png;base64,iVBORw0KGgo= text", []string{"data:image/png;base64,iVBORw0KGgo="}},
+		{"file-20240101123456abc123", []string{"file-20240101123456abc123"}},
+		{"myfile-20240101123456abc123", nil},
+		{"iVBORw0KGgo", nil},
+		{"ftp://example.com/a.png", nil},
+	}
+	for _, tt := range tests {
+		if got := imageTokenRe.FindAllString(tt.text, -1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("imageTokenRe.FindAllString(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
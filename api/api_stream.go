@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 流式进度模式
+const (
+	progressOff     = "off"
+	progressSteps   = "steps"
+	progressPreview = "preview"
+)
+
+// 预览图默认发送间隔（步数）
+const defaultPreviewInterval = 5
+
+// 等待 NovelAI 时发送 SSE 注释保持连接的间隔，首次在该间隔后发送，快速失败的请求仍能返回 HTTP 错误状态码
+const keepAliveInterval = 10 * time.Second
+
+// 聊天回复的结束原因
+const finishReasonStop = "stop"
+
+// ChatCompletion OpenAI 格式的非流式聊天回复
+type ChatCompletion struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   ChatUsage              `json:"usage"`
+}
+
+// ChatCompletionChoice 非流式回复中的单个选项
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// ChatMessage 回复消息
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatUsage token 用量，图像生成不消耗 token，固定为 0
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatChunk OpenAI 格式的流式聊天分片
+type ChatChunk struct {
+	ID      string            `json:"id"`
+	Object  string            `json:"object"`
+	Created int64             `json:"created"`
+	Model   string            `json:"model"`
+	Choices []ChatChunkChoice `json:"choices"`
+}
+
+// ChatChunkChoice 流式分片中的单个选项
+type ChatChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        ChatDelta   `json:"delta"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// ChatDelta 流式分片的增量内容，结束分片为空对象
+type ChatDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// chatWriter 按请求的 stream 字段输出聊天回复
+// 流式模式下首次写入时发送响应头和角色分片，之后无法再返回 HTTP 错误状态码，错误以文本形式追加在内容中；
+// 非流式模式下内容先缓存，结束时一次性返回 chat.completion 对象
+type chatWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	id      string
+	model   string
+	created int64
+	stream  bool
+	started bool
+	written bool // 是否已输出过内容
+	content strings.Builder
+}
+
+// newChatWriter 创建聊天回复
+func newChatWriter(w http.ResponseWriter, model string, stream bool) *chatWriter {
+	created := time.Now().Unix()
+	return &chatWriter{
+		w:       w,
+		id:      fmt.Sprintf("chatcmpl-%d", created),
+		model:   model,
+		created: created,
+		stream:  stream,
+	}
+}
+
+// send 追加一段回复内容
+func (c *chatWriter) send(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write(content)
+}
+
+// finish 结束回复
+func (c *chatWriter) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.end()
+}
+
+// fail 返回错误：尚未开始输出时返回 JSON 错误，否则追加错误文本并结束流
+func (c *chatWriter) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		writeError(c.w, err)
+		return
+	}
+	log.Printf("Request failed after streaming started: %v", err)
+	c.write(fmt.Sprintf("\n生成失败: %v", err))
+	c.end()
+}
+
+// keepAlive 在等待生成期间定时发送 SSE 注释，返回停止函数；非流式模式下不做任何事
+func (c *chatWriter) keepAlive() func() {
+	if !c.stream {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				c.start()
+				c.w.Write([]byte(": keep-alive\n\n"))
+				c.flush()
+				c.mu.Unlock()
+			}
+		}
+	}()
+	// 等待协程退出，避免结束后仍写入注释
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// start 发送流式响应头与角色分片，仅执行一次
+func (c *chatWriter) start() {
+	if c.started {
+		return
+	}
+	c.started = true
+	c.w.Header().Set("Content-Type", "text/event-stream")
+	c.w.Header().Set("Cache-Control", "no-cache")
+	c.w.Header().Set("Connection", "keep-alive")
+	c.chunk(ChatDelta{Role: "assistant"}, nil)
+}
+
+func (c *chatWriter) write(content string) {
+	c.written = true
+	if !c.stream {
+		c.content.WriteString(content)
+		return
+	}
+	c.start()
+	c.chunk(ChatDelta{Content: content}, nil)
+}
+
+func (c *chatWriter) end() {
+	if !c.stream {
+		completion := ChatCompletion{
+			ID:      c.id,
+			Object:  "chat.completion",
+			Created: c.created,
+			Model:   c.model,
+			Choices: []ChatCompletionChoice{{
+				Message:      ChatMessage{Role: "assistant", Content: c.content.String()},
+				FinishReason: finishReasonStop,
+			}},
+		}
+		c.w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(c.w).Encode(completion); err != nil {
+			log.Printf("Failed to encode JSON response: %v", err)
+		}
+		return
+	}
+
+	c.start()
+	reason := finishReasonStop
+	c.chunk(ChatDelta{}, &reason)
+	c.w.Write([]byte("data: [DONE]\n\n"))
+	c.flush()
+}
+
+// chunk 写入一个 SSE 分片
+func (c *chatWriter) chunk(delta ChatDelta, finishReason *string) {
+	data, err := json.Marshal(ChatChunk{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []ChatChunkChoice{{Delta: delta, FinishReason: finishReason}},
+	})
+	if err != nil {
+		log.Printf("Failed to encode chat chunk: %v", err)
+		return
+	}
+	fmt.Fprintf(c.w, "data: %s\n\n", data)
+	c.flush()
+}
+
+func (c *chatWriter) flush() {
+	if flusher, ok := c.w.(http.Flusher); ok {
+		flusher.Flush() // 刷新响应缓冲区到客户端
+	}
+}
+
+// progress 按配置返回生成进度回调，未启用或非流式请求时返回 nil
+// steps 模式发送 "step 12/28" 文本，preview 模式每隔若干步发送一张中间预览图
+func (c *chatWriter) progress(cfg *config.Config) models.ProgressFunc {
+	mode := cfg.Stream.Progress
+	if !c.stream || mode == "" || mode == progressOff {
+		return nil
+	}
+	if mode != progressSteps && mode != progressPreview {
+		log.Printf("Unknown stream.progress %q, progress disabled", mode)
+		return nil
+	}
+	interval := cfg.Stream.PreviewInterval
+	if interval <= 0 {
+		interval = defaultPreviewInterval
+	}
+
+	return func(p models.Progress) {
+		// 批量生成时只转发第一张图像的进度
+		if p.Sample != 0 {
+			return
+		}
+		if mode == progressPreview && len(p.Preview) > 0 && (p.Step%interval == 0 || p.Step == p.Steps) {
+			c.send(fmt.Sprintf("![step %d/%d](data:image/jpeg;base64,%s)\n\n", p.Step, p.Steps, base64.StdEncoding.EncodeToString(p.Preview)))
+			return
+		}
+		c.send(fmt.Sprintf("step %d/%d\n", p.Step, p.Steps))
+	}
+}
+
+// hasContent 是否已输出过内容（如生成进度）
+func (c *chatWriter) hasContent() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written
+}
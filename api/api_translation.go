@@ -8,8 +8,76 @@ import (
 	"log"
 	"net/http"
 	"novel-api/config"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// 单次请求的翻译模式
+const (
+	TranslateAuto   = "auto"   // 默认：启用翻译时翻译，但跳过已是英文标签的提示词
+	TranslateAlways = "always" // 启用翻译时总是翻译
+	TranslateNever  = "never"  // 不翻译
+)
+
+// 英文标签中单个标签的最大单词数，如 "white dress"、"looking at viewer"
+const maxTagWords = 4
+
+// 提示词中短标签的最低占比，达到时视为标签式提示词
+const minTagRatio = 0.8
+
+// 没有 Danbooru 写法时，常见英文单词的最低占比
+const minEnglishRatio = 0.8
+
+// Danbooru 写法：1girl、2boys、6+others 等人数标签与 long_hair 等下划线标签
+var danbooruTagRe = regexp.MustCompile(`(?i)\b\d+\+?(?:girl|boy|other)s?\b|\b[a-z0-9]+_[a-z0-9_]+\b`)
+
+// englishTagWords 常见英文单词与 Danbooru 标签用词，用于确认标签式提示词确实是英文
+var englishTagWords = wordSet(`
+a an the and or of in on at to with without from by for under over behind between near into
+is are wearing holding sitting standing lying looking walking running smiling open closed up down
+girl girls boy boys woman women man men child solo couple multiple people person character characters
+masterpiece best quality high highres absurdres detailed ultra amazing very aesthetic official art
+illustration artwork painting sketch lineart monochrome greyscale colorful realistic anime style
+hair eyes eye face skin body hand hands arm arms leg legs foot feet head ear ears tail wings horns
+long short medium bob ponytail twintails braid braids bangs ahoge messy straight wavy curly
+black white red blue green yellow orange purple pink brown grey gray silver golden gold dark light
+pale blonde multicolored gradient streaked two tone heterochromia
+dress skirt shirt jacket coat hoodie sweater uniform school serafuku kimono yukata swimsuit bikini
+shorts pants jeans thighhighs socks stockings pantyhose shoes boots gloves hat cap ribbon bow
+necklace earrings glasses scarf cape hood armor apron maid nurse suit tie collar belt bag
+smile blush grin frown crying tears angry sad happy surprised shy serious closed expressionless
+mouth teeth tongue lips fang looking viewer away back side behind profile portrait upper full
+cowboy shot close closeup wide view from above below dutch angle pov focus depth field
+background simple outdoors indoors sky cloud clouds sun moon star stars night day sunset sunrise
+rain snow water sea ocean beach river lake forest tree trees flower flowers grass garden field
+city street building room bedroom classroom window door table chair bed sofa bench
+cat dog bird fox wolf rabbit dragon animal fish butterfly
+sword weapon gun umbrella book cup food cake phone
+lighting light shadow glowing glow sparkle bokeh blurry depth reflection cinematic dramatic
+cute beautiful pretty cool elegant small large big huge tiny little young old
+nsfw sfw rating safe general sensitive questionable explicit
+lowres worst bad normal jpeg artifacts blurry watermark signature text username error missing
+extra fewer fingers digits cropped deformed ugly censored
+hd hdr uhd
+`)
+
+// wordSet 将空白分隔的单词列表转换为集合
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// 翻译请求超时
+const translationTimeout = 60 * time.Second
+
+// translationClient 所有翻译请求共用的 HTTP 客户端，复用连接
+var translationClient = &http.Client{Timeout: translationTimeout}
+
 // TranslationRequest 定义翻译请求结构体
 type TranslationRequest struct {
 	Model    string    `json:"model"`
@@ -37,6 +105,97 @@ type TranslationResponse struct {
 	} `json:"usage"`
 }
 
+// normalizeTranslateMode 校验翻译模式，未指定时为 auto
+func normalizeTranslateMode(mode *string) error {
+	*mode = strings.ToLower(strings.TrimSpace(*mode))
+	if *mode == "" {
+		*mode = TranslateAuto
+	}
+	if *mode != TranslateAuto && *mode != TranslateAlways && *mode != TranslateNever {
+		return badRequest("invalid_translate", fmt.Sprintf("translate must be %q, %q or %q", TranslateAuto, TranslateAlways, TranslateNever))
+	}
+	return nil
+}
+
+// translatePrompt 按翻译模式翻译提示词，翻译失败时使用原文
+// auto 模式下拉丁字母书写的标签式提示词（如 Danbooru 标签）直接使用，避免一次多余的翻译请求，也避免 {}、[] 权重被改写
+func translatePrompt(text string, mode string, cfg *config.Config) string {
+	switch {
+	case strings.TrimSpace(text) == "":
+		return text
+	case !cfg.Translation.Enable:
+		log.Printf("Translation is disabled, skipping translation")
+		return text
+	case mode == TranslateNever:
+		log.Printf("Translation skipped by request (translate=never)")
+		return text
+	case mode != TranslateAlways && isTagPrompt(text):
+		log.Printf("Translation skipped, prompt is already English tags: %s", text)
+		return text
+	}
+
+	translated, err := TranslateText(text, cfg)
+	if err != nil {
+		log.Printf("Translation failed, using original text: %v", err)
+		return text
+	}
+	log.Printf("Translation enabled, translated: %s -> %s", text, translated)
+	return translated
+}
+
+// isTagPrompt 判断提示词是否已是英文标签，只有存在明确的英文或 Danbooru 特征时才返回 true：
+// {}、[]、:: 权重语法，或以逗号分隔的短标签为主且带有 1girl、long_hair 这类 Danbooru 写法、绝大多数单词为常见英文单词
+// 只要出现非 ASCII 字母（中文、带重音的拉丁字母等）即视为需要翻译，西班牙语、法语、德语等拉丁字母书写的提示词同样会翻译
+func isTagPrompt(text string) bool {
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if r > unicode.MaxASCII {
+			return false
+		}
+		letters++
+	}
+	if letters == 0 {
+		return true
+	}
+	if strings.ContainsAny(text, "{}[]") || strings.Contains(text, "::") {
+		return true
+	}
+
+	tags, short := 0, 0
+	for _, segment := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		words := len(strings.Fields(segment))
+		if words == 0 {
+			continue
+		}
+		tags++
+		if words <= maxTagWords {
+			short++
+		}
+	}
+	if tags == 0 || float64(short) < minTagRatio*float64(tags) {
+		return false
+	}
+	if danbooruTagRe.MatchString(text) {
+		return true
+	}
+
+	// 其余情况按常见英文单词的占比判断，Katze、chica con gato 等不会被误判
+	words, known := 0, 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return r < 'a' || r > 'z' }) {
+		if len(word) < 2 && word != "a" {
+			continue
+		}
+		words++
+		if englishTagWords[word] {
+			known++
+		}
+	}
+	return words > 0 && float64(known) >= minEnglishRatio*float64(words)
+}
+
 // TranslateText 调用 AI 翻译文本
 func TranslateText(text string, cfg *config.Config) (string, error) {
 	// 检查是否启用翻译
@@ -47,6 +206,16 @@ func TranslateText(text string, cfg *config.Config) (string, error) {
 	}
 	log.Println("Translation is enabled, proceeding with translation")
 
+	// 相同模型、人设与原文的翻译直接使用缓存，重复生成同一提示词时不再调用翻译服务
+	cache := getTranslationCache(cfg)
+	key := translationKey(cfg.Translation.Model, cfg.Translation.Role, text)
+	if cache != nil {
+		if translated, ok := cache.get(key); ok {
+			log.Printf("Translation cache hit: %s -> %s", text, translated)
+			return translated, nil
+		}
+	}
+
 	// 构建翻译请求
 	translateReq := TranslationRequest{
 		Model: cfg.Translation.Model,
@@ -70,7 +239,6 @@ func TranslateText(text string, cfg *config.Config) (string, error) {
 	}
 
 	// 创建 HTTP 请求
-	client := &http.Client{}
 	req, err := http.NewRequest("POST", cfg.Translation.URL+"/v1/chat/completions", bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		log.Printf("Failed to create translation request: %v", err)
@@ -85,7 +253,7 @@ func TranslateText(text string, cfg *config.Config) (string, error) {
 	log.Printf("Translating text: %s", text)
 
 	// 发送请求
-	resp, err := client.Do(req)
+	resp, err := translationClient.Do(req)
 	if err != nil {
 		log.Printf("Failed to send translation request: %v", err)
 		return text, err
@@ -122,6 +290,9 @@ func TranslateText(text string, cfg *config.Config) (string, error) {
 
 	translatedText := translateResp.Choices[0].Message.Content
 	log.Printf("Translation successful: %s -> %s", text, translatedText)
+	if cache != nil && translatedText != "" {
+		cache.put(key, translatedText)
+	}
 
 	return translatedText, nil
 }
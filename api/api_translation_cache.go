@@ -0,0 +1,353 @@
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 翻译缓存未配置时的默认值
+const (
+	defaultTranslationCacheSize = 1000
+	defaultTranslationCacheTTL  = 7 * 24 * time.Hour
+)
+
+// 持久化文件的写入间隔，期间的新翻译合并为一次写入
+const translationCacheFlushInterval = 30 * time.Second
+
+// translationEntry 一条翻译缓存，持久化文件中按从旧到新的使用顺序保存
+type translationEntry struct {
+	Key       string    `json:"key"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	element   *list.Element
+}
+
+// TranslationCacheStats 翻译缓存统计
+type TranslationCacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Entries   int     `json:"entries"`
+	Size      int     `json:"size"`
+	TTL       int     `json:"ttl"` // 秒
+	Path      string  `json:"path,omitempty"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	Evictions int64   `json:"evictions"` // 超出容量被淘汰的条数
+	Expired   int64   `json:"expired"`   // 超过 TTL 被丢弃的条数
+}
+
+// translationCache 翻译结果的 LRU 缓存，可持久化到磁盘
+type translationCache struct {
+	mu      sync.Mutex
+	saveMu  sync.Mutex
+	entries map[string]*translationEntry
+	order   *list.List // 队首为最近使用
+	size    int
+	ttl     time.Duration
+	path    string
+	dirty   bool // 有尚未写入持久化文件的变更
+
+	hits, misses, evictions, expired int64
+}
+
+var (
+	translations     *translationCache
+	translationsOnce sync.Once
+)
+
+// getTranslationCache 首次使用时按配置创建缓存并从磁盘恢复，size 为 -1 时返回 nil
+func getTranslationCache(cfg *config.Config) *translationCache {
+	translationsOnce.Do(func() {
+		size := cfg.TranslationCache.Size
+		if size < 0 {
+			log.Println("Translation cache is disabled")
+			return
+		}
+		if size == 0 {
+			size = defaultTranslationCacheSize
+		}
+		ttl := time.Duration(cfg.TranslationCache.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = defaultTranslationCacheTTL
+		}
+		translations = &translationCache{
+			entries: make(map[string]*translationEntry),
+			order:   list.New(),
+			size:    size,
+			ttl:     ttl,
+			path:    cfg.TranslationCache.Path,
+		}
+		if err := translations.load(); err != nil {
+			log.Printf("Failed to load translation cache: %v", err)
+		}
+		if translations.path != "" {
+			go translations.flushLoop()
+		}
+	})
+	return translations
+}
+
+// translationKey 缓存键：翻译模型 + 人设 + 原文的哈希，修改人设或模型后旧的翻译不再命中
+func translationKey(model, role, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + role + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// get 查找未过期的翻译，命中时移到队首
+func (c *translationCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Since(entry.CreatedAt) > c.ttl {
+		c.remove(entry)
+		c.expired++
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	c.order.MoveToFront(entry.element)
+	c.hits++
+	return entry.Text, true
+}
+
+// put 写入翻译，超出容量时淘汰最久未使用的条目，持久化文件由 flushLoop 定时写入
+func (c *translationCache) put(key, text string) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		c.remove(entry)
+	}
+	entry := &translationEntry{Key: key, Text: text, CreatedAt: time.Now()}
+	entry.element = c.order.PushFront(entry)
+	c.entries[key] = entry
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back().Value.(*translationEntry))
+		c.evictions++
+	}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// remove 删除条目，调用方需持有 mu
+func (c *translationCache) remove(entry *translationEntry) {
+	c.order.Remove(entry.element)
+	delete(c.entries, entry.Key)
+}
+
+// clear 清空缓存与统计
+func (c *translationCache) clear() error {
+	c.mu.Lock()
+	c.entries = make(map[string]*translationEntry)
+	c.order.Init()
+	c.hits, c.misses, c.evictions, c.expired = 0, 0, 0, 0
+	c.mu.Unlock()
+	return c.save()
+}
+
+// stats 返回当前统计
+func (c *translationCache) stats() TranslationCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := TranslationCacheStats{
+		Enabled:   true,
+		Entries:   c.order.Len(),
+		Size:      c.size,
+		TTL:       int(c.ttl / time.Second),
+		Path:      c.path,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Expired:   c.expired,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRate = float64(c.hits) / float64(total)
+	}
+	return s
+}
+
+// flushLoop 定时将变更写入持久化文件，避免每次翻译都在请求中重写整个文件
+func (c *translationCache) flushLoop() {
+	ticker := time.NewTicker(translationCacheFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.flush()
+	}
+}
+
+// flush 有变更时写入持久化文件，写入失败不影响翻译，下次再试
+func (c *translationCache) flush() {
+	c.mu.Lock()
+	dirty := c.dirty
+	c.mu.Unlock()
+	if !dirty {
+		return
+	}
+	if err := c.save(); err != nil {
+		log.Printf("Failed to save translation cache: %v", err)
+	}
+}
+
+// FlushTranslationCache 退出前将翻译缓存写入持久化文件
+func FlushTranslationCache() {
+	if translations != nil {
+		translations.flush()
+	}
+}
+
+// load 从持久化文件恢复缓存，跳过已过期的条目
+func (c *translationCache) load() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []*translationEntry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range saved {
+		if entry.Key == "" || time.Since(entry.CreatedAt) > c.ttl {
+			continue
+		}
+		if old, ok := c.entries[entry.Key]; ok {
+			c.remove(old)
+		}
+		entry.element = c.order.PushFront(entry)
+		c.entries[entry.Key] = entry
+	}
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back().Value.(*translationEntry))
+	}
+	log.Printf("Translation cache loaded: %d entries from %s", c.order.Len(), c.path)
+	return nil
+}
+
+// save 将缓存按从旧到新的顺序写入持久化文件，先写临时文件再重命名，避免重启时读到写了一半的文件
+func (c *translationCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	saved := make([]*translationEntry, 0, c.order.Len())
+	for e := c.order.Back(); e != nil; e = e.Prev() {
+		saved = append(saved, e.Value.(*translationEntry))
+	}
+	data, err := json.Marshal(saved)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 写入失败时保留变更标记，下次定时写入时重试
+	failed := func(err error) error {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return failed(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".translations-*")
+	if err != nil {
+		return failed(err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return failed(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return failed(err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// TranslationCache 查看（GET）或清空（DELETE）翻译缓存，需要日志管理登录的 token
+func TranslationCache(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 验证token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !isValidToken(token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "未授权访问",
+		})
+		return
+	}
+
+	cache := getTranslationCache(cfg)
+	switch r.Method {
+	case http.MethodGet:
+		// 只返回统计
+	case http.MethodDelete:
+		if cache != nil {
+			if err := cache.clear(); err != nil {
+				log.Printf("清空翻译缓存失败: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": false,
+					"message": "清空失败",
+				})
+				return
+			}
+			log.Println("Translation cache cleared")
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "不支持的请求方法",
+		})
+		return
+	}
+
+	stats := TranslationCacheStats{}
+	if cache != nil {
+		stats = cache.stats()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    stats,
+	})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"container/list"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTranslationCache(size int, ttl time.Duration, path string) *translationCache {
+	return &translationCache{
+		entries: make(map[string]*translationEntry),
+		order:   list.New(),
+		size:    size,
+		ttl:     ttl,
+		path:    path,
+	}
+}
+
+func TestTranslationCacheLRU(t *testing.T) {
+	c := newTestTranslationCache(2, time.Hour, "")
+	c.put("a", "A")
+	c.put("b", "B")
+	// 命中 a 后 b 成为最久未使用
+	if text, ok := c.get("a"); !ok || text != "A" {
+		t.Fatalf("get(a) = %q, %v", text, ok)
+	}
+	c.put("c", "C")
+
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "A", true},
+		{"b", "", false},
+		{"c", "C", true},
+	}
+	for _, tt := range tests {
+		if text, ok := c.get(tt.key); text != tt.want || ok != tt.ok {
+			t.Errorf("get(%q) = %q, %v, want %q, %v", tt.key, text, ok, tt.want, tt.ok)
+		}
+	}
+
+	stats := c.stats()
+	if stats.Entries != 2 || stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestTranslationCacheUpdate(t *testing.T) {
+	c := newTestTranslationCache(2, time.Hour, "")
+	c.put("a", "A")
+	c.put("a", "A2")
+	if text, ok := c.get("a"); !ok || text != "A2" {
+		t.Errorf("get(a) = %q, %v, want %q", text, ok, "A2")
+	}
+	if stats := c.stats(); stats.Entries != 1 || stats.Evictions != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestTranslationCacheTTL(t *testing.T) {
+	c := newTestTranslationCache(2, time.Hour, "")
+	c.put("a", "A")
+	c.entries["a"].CreatedAt = time.Now().Add(-2 * time.Hour)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry should not be returned")
+	}
+	if stats := c.stats(); stats.Entries != 0 || stats.Expired != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestTranslationCachePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "translations.json")
+	c := newTestTranslationCache(2, time.Hour, path)
+	c.put("a", "A")
+	c.put("b", "B")
+	c.put("c", "C")
+	c.get("b")
+	c.flush()
+	if c.dirty {
+		t.Error("flush should clear the dirty flag")
+	}
+
+	// 恢复后保持使用顺序，b 为最近使用
+	restored := newTestTranslationCache(1, time.Hour, path)
+	if err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if text, ok := restored.get("b"); !ok || text != "B" {
+		t.Errorf("get(b) = %q, %v, want %q", text, ok, "B")
+	}
+	if _, ok := restored.get("c"); ok {
+		t.Error("c should be dropped when the restored cache is smaller")
+	}
+
+	if err := restored.clear(); err != nil {
+		t.Fatal(err)
+	}
+	empty := newTestTranslationCache(2, time.Hour, path)
+	if err := empty.load(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := empty.stats(); stats.Entries != 0 {
+		t.Errorf("cleared cache restored %d entries", stats.Entries)
+	}
+}
+
+func TestTranslationKey(t *testing.T) {
+	key := translationKey("gpt-4.1-nano", "translator", "一个女孩")
+	if key != translationKey("gpt-4.1-nano", "translator", "一个女孩") {
+		t.Error("key should be stable")
+	}
+	for _, other := range []string{
+		translationKey("gpt-4.1-mini", "translator", "一个女孩"),
+		translationKey("gpt-4.1-nano", "prompt writer", "一个女孩"),
+		translationKey("gpt-4.1-nano", "translator", "两个女孩"),
+	} {
+		if other == key {
+			t.Error("changing the model, role or text should change the key")
+		}
+	}
+}
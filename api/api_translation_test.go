@@ -0,0 +1,67 @@
+package api
+
+import "testing"
+
+func TestIsTagPrompt(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   bool
+	}{
+		// 英文标签
+		{"1girl, solo, long hair, looking at viewer, smile", true},
+		{"1girl, white dress, {{masterpiece}}, [bad hands]", true},
+		{"1.3::red hair::, 1girl", true},
+		{"long_hair, blue_eyes, school_uniform", true},
+		{"masterpiece", true},
+		{"white dress, umbrella, rain, night", true},
+		{"2boys, sword, armor", true},
+		{"", true},
+		{"1234", true},
+
+		// 英文长句仍需翻译为标签
+		{"a girl standing in the rain with an umbrella at night", false},
+		{"a beautiful girl standing in a garden full of flowers, sunset", false},
+
+		// 中文
+		{"一个女孩", false},
+		{"1girl, 白色连衣裙", false},
+
+		// 其他拉丁字母语言
+		{"chica con gato", false},
+		{"Katze", false},
+		{"une fille, cheveux blancs, yeux bleus", false},
+		{"gadis kecil", false},
+		{"café, crème brûlée", false},
+		{"Mädchen mit Hut", false},
+	}
+	for _, tt := range tests {
+		if got := isTagPrompt(tt.prompt); got != tt.want {
+			t.Errorf("isTagPrompt(%q) = %v, want %v", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTranslateMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"", TranslateAuto, false},
+		{"auto", TranslateAuto, false},
+		{" Always ", TranslateAlways, false},
+		{"NEVER", TranslateNever, false},
+		{"maybe", "", true},
+	}
+	for _, tt := range tests {
+		mode := tt.mode
+		err := normalizeTranslateMode(&mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeTranslateMode(%q) error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && mode != tt.want {
+			t.Errorf("normalizeTranslateMode(%q) = %q, want %q", tt.mode, mode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/logs"
+	"novel-api/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认放大倍数
+const defaultUpscaleFactor = 2
+
+// UpscaleRequest 放大请求结构体
+type UpscaleRequest struct {
+	Image          string `json:"image"`                     // 图片链接、data URI 或日志ID
+	Scale          int    `json:"scale,omitempty"`           // 放大倍数，2 或 4，默认 2
+	ResponseFormat string `json:"response_format,omitempty"` // url 或 b64_json
+}
+
+// Upscale 处理 /v1/images/upscale 请求，调用 NovelAI 放大接口
+func Upscale(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 1. 获取 Authorization 请求头的值
+	authHeader := r.Header.Get("Authorization")
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+
+	// 2. 解析请求体
+	var req UpscaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Failed to decode upscale request body: %v", err)
+		writeError(w, badRequest("invalid_json", err.Error()))
+		return
+	}
+	if err := normalizeResponseFormat(&req.ResponseFormat); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 3. 放大并上传
+	stored, err := runUpscale(r, req.Image, req.Scale, req.ResponseFormat, authHeader, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, err := toImageData(stored, "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GenerationResponse{Created: time.Now().Unix(), Data: data}); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// runUpscale 读取来源图片、调用 NovelAI 放大并上传，结果作为新日志关联到来源日志
+func runUpscale(r *http.Request, source string, scale int, responseFormat string, authHeader string, cfg *config.Config) ([]storedImage, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, badRequest("missing_image", "image is required")
+	}
+	if scale == 0 {
+		scale = defaultUpscaleFactor
+	}
+	if scale != 2 && scale != 4 {
+		return nil, badRequest("invalid_scale", "scale must be 2 or 4")
+	}
+
+	// 1. 读取来源图片
+	imageData, sourceLog, err := loadImage(source, cfg)
+	if err != nil {
+		return nil, err
+	}
+	sourceLog = imageSourceLog(source, sourceLog)
+	width, height, err := imageSize(imageData)
+	if err != nil {
+		return nil, badRequest("invalid_image", err.Error())
+	}
+
+	// 2. 调用 NovelAI 放大，loadImage 已将图片转为 PNG
+	upscaled, err := models.Upscale(models.UpscaleRequest{
+		Image:  base64.StdEncoding.EncodeToString(imageData),
+		Width:  width,
+		Height: height,
+		Scale:  scale,
+	}, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Upscale x%d succeeded: %dx%d -> %d bytes", scale, width, height, len(upscaled))
+
+	// 3. 上传并记录日志，关联来源日志
+	entry := logs.ImageLog{
+		Model:  "upscale",
+		Action: "upscale",
+		Prompt: fmt.Sprintf("upscale x%d", scale),
+	}
+	if sourceLog != nil {
+		entry.Model = sourceLog.Model
+		entry.Prompt = sourceLog.Prompt
+		entry.SourceID = sourceLog.ID
+	}
+	result := &models.GenerateResult{
+		Images:     [][]byte{upscaled},
+		NamePrefix: fmt.Sprintf("upscale_x%d_", scale),
+	}
+	return storeImages(r, entry, result, responseFormat, cfg), nil
+}
+
+// 聊天指令中的放大倍数，如 2、4x、x4
+var upscaleFactorRe = regexp.MustCompile(`^[xX]?\d+[xX]?$`)
+
+// handleUpscaleCommand 处理聊天指令：/upscale <图片链接|data URI|日志ID> [2|4]
+// 图片以 image_url 内容块发送时，文本中只需 /upscale [2|4]
+func handleUpscaleCommand(reply *chatWriter, r *http.Request, userInput string, images []string, authHeader string, cfg *config.Config) {
+	fields := strings.Fields(userInput)
+	if len(images) > 0 && (len(fields) < 2 || len(fields) == 2 && upscaleFactorRe.MatchString(fields[1])) {
+		fields = append([]string{fields[0], images[0]}, fields[1:]...)
+	}
+	if len(fields) < 2 {
+		reply.fail(badRequest("missing_image", "usage: /upscale <image url|log id> [2|4]"))
+		return
+	}
+
+	scale := 0
+	if len(fields) > 2 {
+		factor := strings.Trim(strings.ToLower(fields[2]), "x")
+		parsed, err := strconv.Atoi(factor)
+		if err != nil {
+			reply.fail(badRequest("invalid_scale", "scale must be 2 or 4"))
+			return
+		}
+		scale = parsed
+	}
+
+	stopKeepAlive := reply.keepAlive()
+	stored, err := runUpscale(r, fields[1], scale, ResponseFormatURL, authHeader, cfg)
+	stopKeepAlive()
+	if err != nil {
+		reply.fail(err)
+		return
+	}
+	writeChatImages(reply, markdownLinks(stored))
+}
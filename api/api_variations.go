@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"novel-api/config"
+	"novel-api/models"
+	"strings"
+)
+
+// 变体默认图生图强度
+const defaultVariationStrength = 0.5
+
+// Variations 处理 OpenAI 兼容的 /v1/images/variations 请求，基于 NovelAI img2img 生成 n 张变体
+func Variations(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	// 设置 CORS 头
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// 如果是 OPTIONS 请求，直接返回 200 OK
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 1. 获取 Authorization 请求头的值
+	authHeader := r.Header.Get("Authorization")
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+
+	// 2. 解析 multipart 表单，prompt 可选
+	req, err := decodeImageForm(w, r, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// 3. 图生图参数，每张变体使用各自的种子
+	req.action = models.ActionImg2Img
+	req.strength, err = formFloat(r, "strength", variationStrength(cfg), 0.01, 0.99)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.noise, err = formFloat(r, "noise", 0, 0, 0.99)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Printf("Variation request: Model=%s, N=%d, Strength=%.2f, Noise=%.2f", req.Model, req.N, req.strength, req.noise)
+
+	// 4. 调用生成流程
+	response, err := runGeneration(r, req, authHeader, cfg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// variationStrength 变体默认强度，取自配置 variation_strength
+func variationStrength(cfg *config.Config) float64 {
+	if cfg.Parameters.VariationStrength <= 0 || cfg.Parameters.VariationStrength >= 1 {
+		return defaultVariationStrength
+	}
+	return cfg.Parameters.VariationStrength
+}
@@ -1,15 +1,90 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // ChatRequest 定义请求结构体
 type ChatRequest struct {
 	Authorization string    `json:"Authorization"`
 	Messages      []Message `json:"messages"`
 	Model         string    `json:"model"`
+	Stream        bool      `json:"stream"`    // 是否以 SSE 流式返回，默认返回完整的 chat.completion 对象
+	Translate     string    `json:"translate"` // 翻译模式：auto（默认）、always 或 never
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string         `json:"role"`
+	Content MessageContent `json:"content"`
+}
+
+// MessageContent 消息内容，兼容纯文本与 OpenAI 多模态内容块数组
+type MessageContent []ContentPart
+
+// ContentPart 多模态内容块，type 为 text 或 image_url
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 图片内容块，url 可以是 http(s) 链接或 data URI
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// UnmarshalJSON 纯文本内容按单个 text 内容块处理
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = nil
+		if text != nil {
+			*c = MessageContent{{Type: "text", Text: *text}}
+		}
+		return nil
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+	*c = parts
+	return nil
+}
+
+// UnmarshalJSON 兼容部分客户端直接以字符串发送 image_url
+func (u *ImageURL) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		u.URL = url
+		return nil
+	}
+	type imageURL ImageURL
+	return json.Unmarshal(data, (*imageURL)(u))
+}
+
+// Text 以换行拼接所有文本内容块
+func (c MessageContent) Text() string {
+	var texts []string
+	for _, part := range c {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ImageURLs 返回所有图片内容块的地址
+func (c MessageContent) ImageURLs() []string {
+	var urls []string
+	for _, part := range c {
+		if part.Type == "image_url" && part.ImageURL != nil && part.ImageURL.URL != "" {
+			urls = append(urls, part.ImageURL.URL)
+		}
+	}
+	return urls
 }
 
 type Config struct {
@@ -72,6 +147,46 @@ type Config struct {
 		StrategyID int    `yaml:"strategy_id"` // 存储策略ID，可选
 	} `yaml:"lsky"`
 
+	// V4 vibe 编码缓存
+	VibeCache struct {
+		Dir string `yaml:"dir"` // 缓存目录，默认 cache/vibes
+	} `yaml:"vibe_cache"`
+
+	// 翻译结果缓存，按 翻译模型 + 人设 + 原文 缓存，重复的提示词不再调用翻译服务
+	TranslationCache struct {
+		Size int    `yaml:"size"` // 最多缓存条数，超出时淘汰最久未使用的，默认 1000，-1 关闭缓存
+		TTL  int    `yaml:"ttl"`  // 过期秒数，默认 7 天
+		Path string `yaml:"path"` // 持久化文件，重启后恢复缓存，为空时只缓存在内存中
+	} `yaml:"translation_cache"`
+
+	// 图片下载（参考图、图生图源图像等）的限制
+	ImageFetch struct {
+		MaxSize      int      `yaml:"max_size"`      // 单张图片最大字节数，默认 10 MB
+		MaxPixels    int      `yaml:"max_pixels"`    // 单张图片最大像素数（宽×高），默认 4096×4096，避免解码小文件大尺寸的图片耗尽内存
+		Timeout      int      `yaml:"timeout"`       // 下载超时秒数，默认 20
+		AllowedHosts []string `yaml:"allowed_hosts"` // 只允许从这些域名下载，为空时不限制，支持 *.example.com
+		PrivateHosts []string `yaml:"private_hosts"` // 允许解析到内网地址的域名，已配置的存储服务自动包含
+	} `yaml:"image_fetch"`
+
+	// 参考图（vibe transfer）默认值，请求中可按图片单独设置
+	References struct {
+		Strength             *float64 `yaml:"strength"`              // 默认参考强度，默认 0.6
+		InformationExtracted *float64 `yaml:"information_extracted"` // 默认信息提取量，默认 1
+		Max                  int      `yaml:"max"`                   // 单次请求最多参考图数量，默认 4
+	} `yaml:"references"`
+
+	// V4 流式生成进度，仅对 chat 接口生效
+	Stream struct {
+		Progress        string `yaml:"progress"`         // off（默认）、steps 或 preview
+		PreviewInterval int    `yaml:"preview_interval"` // preview 模式下每隔多少步发送一次预览图，默认 5
+	} `yaml:"stream"`
+
+	// 按模型名称覆盖的默认参数，未设置的字段使用 parameters 中的值
+	Models map[string]ModelConfig `yaml:"models"`
+
+	// 模型别名，如 dall-e-3 → nai-diffusion-4-5-full，可附带参数预设
+	ModelAliases map[string]ModelAlias `yaml:"model_aliases"`
+
 	// 图片质量变量
 	Parameters struct {
 		ParamsVersion                      int     `yaml:"params_version"`
@@ -96,11 +211,38 @@ type Config struct {
 		DeliberateEulerAncestralBug        bool    `yaml:"deliberate_euler_ancestral_bug"`
 		PreferBrownian                     bool    `yaml:"prefer_brownian"`
 		CustomAntiWords                    string  `yaml:"custom_anti_words"`
+		QualityTags                        *string `yaml:"quality_tags"`
 		AutoSmea                           bool    `yaml:"autoSmea"`
 		UseCoords                          bool    `yaml:"use_coords"`
 		LegacyUC                           bool    `yaml:"legacy_uc"`
 		NormalizeReferenceStrengthMultiple bool    `yaml:"normalize_reference_strength_multiple"`
-		InpaintImg2ImgStrength             int     `yaml:"inpaintImg2ImgStrength"`
+		InpaintImg2ImgStrength             float64 `yaml:"inpaintImg2ImgStrength"` // 局部重绘默认强度（0.01-1），默认 1
+		VariationStrength                  float64 `yaml:"variation_strength"`
 		UseNewSharedTrial                  bool    `yaml:"use_new_shared_trial"`
 	} `yaml:"parameters"`
 }
+
+// ModelConfig 单个模型的默认参数
+type ModelConfig struct {
+	QualityTags    *string `yaml:"quality_tags"`    // 追加在提示词后的质量词，设为空字符串可关闭
+	NegativePrompt *string `yaml:"negative_prompt"` // 反向提示词，替换 custom_anti_words
+	UCPreset       *int    `yaml:"ucPreset"`
+	Width          int     `yaml:"width"`
+	Height         int     `yaml:"height"`
+	Sampler        string  `yaml:"sampler"`
+	Steps          int     `yaml:"steps"`
+}
+
+// ModelAlias 模型别名及其参数预设，预设优先于模型默认参数，请求中的 nai_parameters 与聊天指令优先于预设
+type ModelAlias struct {
+	Model          string   `yaml:"model"` // 实际使用的模型
+	Width          int      `yaml:"width"`
+	Height         int      `yaml:"height"`
+	Steps          *int     `yaml:"steps"`
+	Scale          *float64 `yaml:"scale"`
+	Sampler        *string  `yaml:"sampler"`
+	NoiseSchedule  *string  `yaml:"noise_schedule"`
+	CFGRescale     *float64 `yaml:"cfg_rescale"`
+	NegativePrompt *string  `yaml:"negative_prompt"`
+	Variety        *bool    `yaml:"variety"`
+}
@@ -7,6 +7,8 @@ toolchain go1.24.7
 require (
 	github.com/minio/minio-go/v7 v7.0.95
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.45
+	github.com/tinylib/msgp v1.3.0
+	golang.org/x/image v0.25.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -25,7 +27,6 @@ require (
 	github.com/mozillazg/go-httpheader v0.2.1 // indirect
 	github.com/philhofer/fwd v1.2.0 // indirect
 	github.com/rs/xid v1.6.0 // indirect
-	github.com/tinylib/msgp v1.3.0 // indirect
 	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
@@ -12,11 +12,14 @@ type ImageLog struct {
 	ID        string    `json:"id"`
 	Timestamp time.Time `json:"timestamp"`
 	Model     string    `json:"model"`
+	Action    string    `json:"action,omitempty"` // generate, img2img, infill, upscale, upload 或增强工具名
 	Prompt    string    `json:"prompt"`
+	Seed      *int      `json:"seed,omitempty"` // 生成该图片使用的种子，可用于复现；放大、上传等非生成记录为空
 	ImageURL  string    `json:"image_url"`
 	UserIP    string    `json:"user_ip"`
 	Status    string    `json:"status"` // success, failed
 	Error     string    `json:"error,omitempty"`
+	SourceID  string    `json:"source_id,omitempty"` // 来源图片的日志ID（如放大结果）
 }
 
 var (
@@ -140,9 +143,37 @@ func GetLogByID(id string) (*ImageLog, error) {
 	return nil, nil
 }
 
+// GetLogByImageURL 根据图片URL获取最近一条日志
+func GetLogByImageURL(imageURL string) (*ImageLog, error) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var found *ImageLog
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var log ImageLog
+		if err := decoder.Decode(&log); err != nil {
+			continue
+		}
+		if log.ImageURL == imageURL {
+			entry := log
+			found = &entry
+		}
+	}
+
+	return found, nil
+}
+
 // containsKeyword 检查日志是否包含关键词
 func containsKeyword(log ImageLog, keyword string) bool {
 	return contains(log.Model, keyword) ||
+		contains(log.Action, keyword) ||
 		contains(log.Prompt, keyword) ||
 		contains(log.UserIP, keyword) ||
 		contains(log.Status, keyword)
@@ -162,6 +193,11 @@ func indexOf(s, substr string) int {
 	return -1
 }
 
+// NewID 生成日志ID，用于需要在记录前得知ID的场景（如上传文件）
+func NewID() string {
+	return generateID()
+}
+
 // generateID 生成唯一ID
 func generateID() string {
 	return time.Now().Format("20060102150405") + randomString(6)
@@ -8,6 +8,9 @@ import (
 	"novel-api/api"
 	"novel-api/config"
 	"novel-api/logs"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"gopkg.in/yaml.v2"
 )
@@ -34,11 +37,35 @@ func main() {
 	defer logs.Close()
 
 	// 启动路由 - API路由
+	http.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		api.Models(w, r, &cfg)
+	})
+	http.HandleFunc("/v1/models/", func(w http.ResponseWriter, r *http.Request) {
+		api.Models(w, r, &cfg)
+	})
 	http.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
 		api.Completions(w, r, &cfg)
 	})
 	http.HandleFunc("/v1/images/generations", func(w http.ResponseWriter, r *http.Request) {
-		api.Generations(w, r, &cfg)
+		api.Generations(w, r, &cfg, true)
+	})
+	http.HandleFunc("/v1/images/generations/json", func(w http.ResponseWriter, r *http.Request) {
+		api.Generations(w, r, &cfg, false)
+	})
+	http.HandleFunc("/v1/images/edits", func(w http.ResponseWriter, r *http.Request) {
+		api.Edits(w, r, &cfg)
+	})
+	http.HandleFunc("/v1/images/variations", func(w http.ResponseWriter, r *http.Request) {
+		api.Variations(w, r, &cfg)
+	})
+	http.HandleFunc("/v1/images/upscale", func(w http.ResponseWriter, r *http.Request) {
+		api.Upscale(w, r, &cfg)
+	})
+	http.HandleFunc("/v1/images/augment", func(w http.ResponseWriter, r *http.Request) {
+		api.Augment(w, r, &cfg)
+	})
+	http.HandleFunc("/v1/files", func(w http.ResponseWriter, r *http.Request) {
+		api.Files(w, r, &cfg)
 	})
 
 	// 日志管理API路由
@@ -47,6 +74,9 @@ func main() {
 	})
 	http.HandleFunc("/api/logs", api.QueryLogs)
 	http.HandleFunc("/api/logs/detail", api.GetLogDetail)
+	http.HandleFunc("/api/translation/cache", func(w http.ResponseWriter, r *http.Request) {
+		api.TranslationCache(w, r, &cfg)
+	})
 
 	// 前端页面路由
 	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +86,17 @@ func main() {
 		http.ServeFile(w, r, "web/logs.html")
 	})
 
+	// 收到退出信号时写入翻译缓存并关闭日志文件
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down, flushing translation cache")
+		api.FlushTranslationCache()
+		logs.Close()
+		os.Exit(0)
+	}()
+
 	log.Println("Starting server on : ", cfg.Server.Addr)
 	log.Println("日志查询页面: http://localhost:" + cfg.Server.Addr + "/logs")
 	log.Println("默认管理密码: " + cfg.LogsAdmin.Password)
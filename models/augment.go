@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"novel-api/config"
+)
+
+// NovelAI 图像增强（Director Tools）接口地址
+const augmentImageURL = "https://image.novelai.net/ai/augment-image"
+
+// 支持的增强工具
+const (
+	ToolBackgroundRemoval = "bg-removal"
+	ToolLineArt           = "lineart"
+	ToolSketch            = "sketch"
+	ToolColorize          = "colorize"
+	ToolEmotion           = "emotion"
+	ToolDeclutter         = "declutter"
+)
+
+// AugmentTools 全部增强工具
+var AugmentTools = []string{ToolBackgroundRemoval, ToolLineArt, ToolSketch, ToolColorize, ToolEmotion, ToolDeclutter}
+
+// Emotions emotion 工具支持的表情
+var Emotions = []string{
+	"neutral", "happy", "sad", "angry", "scared", "surprised", "tired", "excited",
+	"nervous", "thinking", "confused", "shy", "disgusted", "smug", "bored", "laughing",
+	"irritated", "aroused", "embarrassed", "worried", "love", "determined", "hurt", "playful",
+}
+
+// AugmentRequest 图像增强请求参数
+type AugmentRequest struct {
+	Tool    string // 增强工具
+	Image   string // 源图像 base64 (PNG)
+	Width   int    // 源图像宽度
+	Height  int    // 源图像高度
+	Prompt  string // 提示词，仅 colorize / emotion 使用
+	Emotion string // 表情，仅 emotion 使用
+	Defry   int    // 降低强度 0-5，仅 colorize / emotion 使用
+}
+
+// Augment 调用 NovelAI 图像增强接口
+func Augment(aug AugmentRequest, authHeader string, cfg *config.Config) ([][]byte, error) {
+	log.Printf("Preparing augment request: tool=%s, %dx%d", aug.Tool, aug.Width, aug.Height)
+
+	payload := map[string]interface{}{
+		"req_type":             aug.Tool,
+		"image":                aug.Image,
+		"width":                aug.Width,
+		"height":               aug.Height,
+		"use_new_shared_trial": cfg.Parameters.UseNewSharedTrial,
+	}
+
+	switch aug.Tool {
+	case ToolColorize:
+		payload["prompt"] = aug.Prompt
+		payload["defry"] = aug.Defry
+	case ToolEmotion:
+		// emotion 的提示词格式为 "表情;;附加提示词"
+		payload["prompt"] = aug.Emotion + ";;" + aug.Prompt
+		payload["defry"] = aug.Defry
+	case ToolBackgroundRemoval, ToolLineArt, ToolSketch, ToolDeclutter:
+	default:
+		return nil, fmt.Errorf("unsupported augment tool: %s", aug.Tool)
+	}
+
+	bodyBytes, err := postNovelAI(augmentImageURL, payload, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	return unzipImages(bodyBytes)
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"novel-api/config"
+)
+
+// 未配置 quality_tags 时各模型族内置的质量词
+const (
+	defaultV3QualityTags = "best quality, amazing quality, very aesthetic, absurdres"
+	defaultV4QualityTags = "best quality, very aesthetic, absurdres"
+)
+
+// Defaults 某个模型生效的默认参数
+type Defaults struct {
+	QualityTags    string
+	NegativePrompt string
+	UCPreset       int
+	Width          int
+	Height         int
+	Sampler        string
+	Steps          int
+}
+
+// ModelDefaults 按 models.<模型名> → parameters → 模型注册信息 的优先级合并模型默认参数
+func ModelDefaults(model string, cfg *config.Config) Defaults {
+	// 未注册的模型按 V3 处理
+	qualityTags := defaultV3QualityTags
+	if info, ok := LookupModel(model); ok {
+		qualityTags = info.QualityTags
+	}
+
+	d := Defaults{
+		QualityTags:    qualityTags,
+		NegativePrompt: cfg.Parameters.CustomAntiWords,
+		UCPreset:       cfg.Parameters.UCPreset,
+		Width:          cfg.Parameters.Width,
+		Height:         cfg.Parameters.Height,
+		Sampler:        cfg.Parameters.Sampler,
+		Steps:          cfg.Parameters.Steps,
+	}
+	if cfg.Parameters.QualityTags != nil {
+		d.QualityTags = *cfg.Parameters.QualityTags
+	}
+
+	m, ok := cfg.Models[model]
+	if !ok {
+		return d
+	}
+	if m.QualityTags != nil {
+		d.QualityTags = *m.QualityTags
+	}
+	if m.NegativePrompt != nil {
+		d.NegativePrompt = *m.NegativePrompt
+	}
+	if m.UCPreset != nil {
+		d.UCPreset = *m.UCPreset
+	}
+	if m.Width > 0 && m.Height > 0 {
+		d.Width, d.Height = m.Width, m.Height
+	}
+	if m.Sampler != "" {
+		d.Sampler = m.Sampler
+	}
+	if m.Steps > 0 {
+		d.Steps = m.Steps
+	}
+	return d
+}
+
+// withQualityTags 在提示词后追加质量词
+func (d Defaults) withQualityTags(prompt string) string {
+	if d.QualityTags == "" {
+		return prompt
+	}
+	return joinPrompt(prompt, d.QualityTags)
+}
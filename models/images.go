@@ -0,0 +1,111 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// extractImages 按序号提取 ZIP 中所有 image_N.png 图像
+func extractImages(zipReader *zip.Reader) ([][]byte, error) {
+	type indexedImage struct {
+		index int
+		data  []byte
+	}
+
+	var found []indexedImage
+	for _, file := range zipReader.File {
+		var index int
+		if _, err := fmt.Sscanf(file.Name, "image_%d.png", &index); err != nil {
+			continue
+		}
+
+		imageData, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, indexedImage{index: index, data: imageData})
+	}
+
+	// 其他接口（如放大）返回的文件名不带序号，按 ZIP 中的顺序提取 PNG
+	if len(found) == 0 {
+		for i, file := range zipReader.File {
+			if !strings.HasSuffix(strings.ToLower(file.Name), ".png") {
+				continue
+			}
+			imageData, err := readZipFile(file)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, indexedImage{index: i, data: imageData})
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("ZIP 中没有找到图像文件")
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].index < found[j].index })
+
+	images := make([][]byte, 0, len(found))
+	for _, img := range found {
+		images = append(images, img.data)
+	}
+	return images, nil
+}
+
+// readZipFile 读取 ZIP 中单个文件的内容
+func readZipFile(file *zip.File) ([]byte, error) {
+	srcFile, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("打开 ZIP 中的文件失败: %v", err)
+	}
+	defer srcFile.Close()
+
+	imageData, err := io.ReadAll(srcFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取图像数据失败: %v", err)
+	}
+	return imageData, nil
+}
+
+// pngSeed 从 NovelAI PNG 的 Comment 文本块中读取实际使用的种子
+func pngSeed(data []byte) (int, bool) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return 0, false
+	}
+
+	pos := len(signature)
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		start := pos + 8
+		end := start + length
+		if length < 0 || end+4 > len(data) {
+			return 0, false
+		}
+
+		if chunkType == "tEXt" {
+			chunk := data[start:end]
+			if sep := bytes.IndexByte(chunk, 0); sep >= 0 && string(chunk[:sep]) == "Comment" {
+				var comment struct {
+					Seed *int `json:"seed"`
+				}
+				if err := json.Unmarshal(chunk[sep+1:], &comment); err == nil && comment.Seed != nil {
+					return *comment.Seed, true
+				}
+			}
+		}
+		if chunkType == "IEND" {
+			return 0, false
+		}
+		pos = end + 4
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngWithText 生成 1x1 的 PNG，并在 IHDR 之后插入 tEXt 文本块
+func pngWithText(t *testing.T, keyword, text string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if keyword == "" {
+		return data
+	}
+
+	// 8 字节文件头 + IHDR（4 字节长度 + 4 字节类型 + 13 字节数据 + 4 字节 CRC）
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	content := append([]byte("tEXt"+keyword+"\x00"), text...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(content)-4))
+	chunk = append(chunk, content...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(content))
+
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestPNGSeed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		seed int
+		ok   bool
+	}{
+		{"seed", pngWithText(t, "Comment", `{"prompt": "1girl", "seed": 2837461923, "steps": 28}`), 2837461923, true},
+		{"seed 0", pngWithText(t, "Comment", `{"seed": 0}`), 0, true},
+		{"no seed", pngWithText(t, "Comment", `{"prompt": "1girl"}`), 0, false},
+		{"other keyword", pngWithText(t, "Description", `{"seed": 42}`), 0, false},
+		{"invalid json", pngWithText(t, "Comment", `seed: 42`), 0, false},
+		{"no text", pngWithText(t, "", ""), 0, false},
+		{"not png", []byte("GIF89a"), 0, false},
+		{"truncated", pngWithText(t, "Comment", `{"seed": 42}`)[:40], 0, false},
+		{"empty", nil, 0, false},
+	}
+	for _, tt := range tests {
+		seed, ok := pngSeed(tt.data)
+		if seed != tt.seed || ok != tt.ok {
+			t.Errorf("%s: pngSeed() = %d, %v, want %d, %v", tt.name, seed, ok, tt.seed, tt.ok)
+		}
+	}
+}
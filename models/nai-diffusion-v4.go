@@ -1,17 +1,8 @@
 package models
 
 import (
-	"archive/zip"
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"novel-api/config"
-	"novel-api/logs"
-	"novel-api/upload"
-	"time"
 )
 
 // CharacterPrompt 定义角色提示词结构
@@ -22,12 +13,15 @@ type CharacterPrompt struct {
 	Enabled bool   `json:"enabled"`
 }
 
-// Center 定义中心点坐标
+// Center 定义中心点坐标，取值 0-1，以画面左上角为原点
 type Center struct {
-	X int `json:"x"`
-	Y int `json:"y"`
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
 }
 
+// MaxCharacters 单次生成允许的最大角色数量
+const MaxCharacters = 6
+
 // V4Prompt 定义 v4 提示词结构
 type V4Prompt struct {
 	Caption   V4Caption `json:"caption"`
@@ -53,6 +47,23 @@ type V4NegativePrompt struct {
 	LegacyUC bool      `json:"legacy_uc"`
 }
 
+// CharacterReference 定义 NAI 4.5 角色参考（director reference）
+type CharacterReference struct {
+	Image      string  // 参考图像 base64 (PNG)，已缩放到 NovelAI 接受的尺寸
+	Strength   float64 // 参考强度 0-1
+	Fidelity   float64 // 保真度 0-1
+	StyleAware bool    // 是否同时参考画风
+}
+
+// DirectorReferenceDescription 定义角色参考的描述结构
+type DirectorReferenceDescription struct {
+	Caption  V4Caption `json:"caption"`
+	LegacyUC bool      `json:"legacy_uc"`
+}
+
+// 角色参考图像接受的尺寸
+var CharacterReferenceSizes = [][2]int{{1024, 1536}, {1536, 1024}, {1472, 1472}}
+
 // NAI4Response 定义 NAI-4 MessagePack 响应结构
 type NAI4Response struct {
 	EventType string  `msgp:"event_type"`
@@ -63,26 +74,30 @@ type NAI4Response struct {
 	Image     []byte  `msgp:"image"`
 }
 
-func Nai4(w http.ResponseWriter, r *http.Request, req config.ChatRequest, randomSeed int, base64String string, authHeader string, cfg *config.Config, userInput string, characterPrompts []CharacterPrompt) {
-	Nai4WithFormat(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, characterPrompts, false)
-}
+// Nai4 调用 NAI Diffusion 4 / 4.5 系列模型生成图像
+func Nai4(gen GenerateRequest, authHeader string, cfg *config.Config) (*GenerateResult, error) {
+	log.Println("Preparing payload for NAI-4 API request.")
 
-func Nai4WithFormat(w http.ResponseWriter, r *http.Request, req config.ChatRequest, randomSeed int, base64String string, authHeader string, cfg *config.Config, userInput string, characterPrompts []CharacterPrompt, isDallRequest bool) {
-	Nai4WithFormatAndSize(w, r, req, randomSeed, base64String, authHeader, cfg, userInput, characterPrompts, cfg.Parameters.Width, cfg.Parameters.Height, isDallRequest)
-}
+	// 生成数量至少为 1
+	if gen.NSamples < 1 {
+		gen.NSamples = 1
+	}
 
-func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.ChatRequest, randomSeed int, base64String string, authHeader string, cfg *config.Config, userInput string, characterPrompts []CharacterPrompt, width int, height int, isDallRequest bool) {
-	// 请求连接
-	apiURL := "https://image.novelai.net/ai/generate-image"
-	log.Println("Preparing payload for NAI-4 API request.")
+	// 模型默认参数（质量词、反词、采样器等）
+	defaults := ModelDefaults(gen.Model, cfg)
+	// 单次请求的反向提示词覆盖
+	defaults.NegativePrompt = gen.Overrides.negativePrompt(defaults.NegativePrompt)
+	prompt := defaults.withQualityTags(gen.Prompt)
 
-	// 构建 characterPrompts
+	// 构建 characterPrompts，显式传入角色时按角色位置生成
+	characterPrompts := gen.CharacterPrompts
+	useCoords := cfg.Parameters.UseCoords || len(characterPrompts) > 0
 	if len(characterPrompts) == 0 {
 		// 默认角色提示词，使用配置文件中的反词
 		characterPrompts = []CharacterPrompt{
 			{
-				Prompt:  userInput + ", best quality, very aesthetic, absurdres",
-				UC:      cfg.Parameters.CustomAntiWords,
+				Prompt:  prompt,
+				UC:      defaults.NegativePrompt,
 				Center:  Center{X: 0, Y: 0},
 				Enabled: true,
 			},
@@ -102,10 +117,10 @@ func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.Ch
 
 	v4Prompt := V4Prompt{
 		Caption: V4Caption{
-			BaseCaption:  userInput + ", best quality, very aesthetic, absurdres",
+			BaseCaption:  prompt,
 			CharCaptions: charCaptions,
 		},
-		UseCoords: cfg.Parameters.UseCoords,
+		UseCoords: useCoords,
 		UseOrder:  true,
 	}
 
@@ -122,7 +137,7 @@ func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.Ch
 
 	v4NegativePrompt := V4NegativePrompt{
 		Caption: V4Caption{
-			BaseCaption:  cfg.Parameters.CustomAntiWords,
+			BaseCaption:  defaults.NegativePrompt,
 			CharCaptions: negativeCharCaptions,
 		},
 		LegacyUC: cfg.Parameters.LegacyUC,
@@ -130,19 +145,19 @@ func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.Ch
 
 	// 支持自定义 payload
 	payload := map[string]interface{}{
-		"input":  userInput + ", best quality, very aesthetic, absurdres",
-		"model":  req.Model,
+		"input":  prompt,
+		"model":  gen.Model,
 		"action": "generate",
 		"parameters": map[string]interface{}{
 			"params_version":                        cfg.Parameters.ParamsVersion,
-			"width":                                 width,
-			"height":                                height,
+			"width":                                 gen.Width,
+			"height":                                gen.Height,
 			"scale":                                 cfg.Parameters.Scale,
-			"sampler":                               cfg.Parameters.Sampler,
-			"steps":                                 cfg.Parameters.Steps,
-			"seed":                                  randomSeed,
-			"n_samples":                             cfg.Parameters.NSamples,
-			"ucPreset":                              cfg.Parameters.UCPreset,
+			"sampler":                               defaults.Sampler,
+			"steps":                                 defaults.Steps,
+			"seed":                                  gen.Seed,
+			"n_samples":                             gen.NSamples,
+			"ucPreset":                              defaults.UCPreset,
 			"qualityToggle":                         cfg.Parameters.QualityToggle,
 			"autoSmea":                              cfg.Parameters.AutoSmea,
 			"dynamic_thresholding":                  cfg.Parameters.DynamicThresholding,
@@ -153,14 +168,14 @@ func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.Ch
 			"noise_schedule":                        cfg.Parameters.NoiseSchedule,
 			"legacy_v3_extend":                      cfg.Parameters.LegacyV3Extend,
 			"skip_cfg_above_sigma":                  cfg.Parameters.SkipCFGAboveSigma,
-			"use_coords":                            cfg.Parameters.UseCoords,
+			"use_coords":                            useCoords,
 			"legacy_uc":                             cfg.Parameters.LegacyUC,
 			"normalize_reference_strength_multiple": cfg.Parameters.NormalizeReferenceStrengthMultiple,
 			"inpaintImg2ImgStrength":                cfg.Parameters.InpaintImg2ImgStrength,
 			"characterPrompts":                      characterPrompts,
 			"v4_prompt":                             v4Prompt,
 			"v4_negative_prompt":                    v4NegativePrompt,
-			"negative_prompt":                       cfg.Parameters.CustomAntiWords,
+			"negative_prompt":                       defaults.NegativePrompt,
 			"deliberate_euler_ancestral_bug":        cfg.Parameters.DeliberateEulerAncestralBug,
 			"prefer_brownian":                       cfg.Parameters.PreferBrownian,
 		},
@@ -168,197 +183,59 @@ func Nai4WithFormatAndSize(w http.ResponseWriter, r *http.Request, req config.Ch
 		"recaptcha_token":      " ",
 	}
 
-	// 根据是否有有效的 base64String 来决定是否添加参考图像字段
-	if base64String != "" {
-		payload["parameters"].(map[string]interface{})["reference_image_multiple"] = []interface{}{base64String}
-		payload["parameters"].(map[string]interface{})["reference_information_extracted_multiple"] = []interface{}{1}
-		payload["parameters"].(map[string]interface{})["reference_strength_multiple"] = []interface{}{0.6}
-	}
-
-	// 将 payload 转换为 JSON
-	payloadBytes, _ := json.Marshal(payload)
-	log.Println("NAI-4 payload marshaled to JSON")
-
-	// 创建新的请求
-	client := &http.Client{}
-	request, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		log.Printf("Failed to create new request: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	log.Println("NAI-4 API request created successfully")
-
-	// 设置请求头
-	request.Header.Set("Authorization", "Bearer "+authHeader)
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept", "*/*")
-	request.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
-	request.Header.Set("Cache-Control", "no-cache")
-	request.Header.Set("Origin", "https://novelai.net")
-	request.Header.Set("Pragma", "no-cache")
-	request.Header.Set("Referer", "https://novelai.net/")
-	log.Println("NAI-4 request headers set.")
-
-	// 发送请求
-	resp, err := client.Do(request)
-	if err != nil {
-		log.Printf("(NAI-4 发送请求失败)Failed to send request: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// V4 的 vibe transfer 需要先将参考图像编码，information_extracted 已包含在编码结果中
+	if len(gen.References) > 0 {
+		encodings := make([]interface{}, 0, len(gen.References))
+		strengths := make([]interface{}, 0, len(gen.References))
+		for i, ref := range gen.References {
+			encoding, err := EncodeVibe(ref.Image, ref.InformationExtracted, gen.Model, authHeader, cfg)
+			if err != nil {
+				log.Printf("Failed to encode vibe %d: %v", i+1, err)
+				return nil, err
+			}
+			encodings = append(encodings, encoding)
+			strengths = append(strengths, ref.Strength)
+		}
+		payload["parameters"].(map[string]interface{})["reference_image_multiple"] = encodings
+		payload["parameters"].(map[string]interface{})["reference_strength_multiple"] = strengths
+		log.Printf("Using %d vibe references", len(gen.References))
 	}
-	defer resp.Body.Close()
 
-	// 检查HTTP响应状态
-	log.Printf("NAI-4 HTTP Response Status: %d %s", resp.StatusCode, resp.Status)
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("NAI-4 API Error Response: %s", string(bodyBytes))
-		http.Error(w, fmt.Sprintf("NAI-4 API request failed with status %d: %s", resp.StatusCode, string(bodyBytes)), resp.StatusCode)
-		return
+	// NAI 4.5 角色参考
+	if ref := gen.CharacterReference; ref != nil {
+		caption := "character"
+		if ref.StyleAware {
+			caption = "character&style"
+		}
+		parameters := payload["parameters"].(map[string]interface{})
+		parameters["director_reference_images"] = []interface{}{ref.Image}
+		parameters["director_reference_descriptions"] = []interface{}{
+			DirectorReferenceDescription{
+				Caption: V4Caption{BaseCaption: caption, CharCaptions: []CharCaption{}},
+			},
+		}
+		parameters["director_reference_information_extracted"] = []interface{}{1}
+		parameters["director_reference_strength_values"] = []interface{}{ref.Strength}
+		// NovelAI 以 1 - fidelity 表示次要强度
+		parameters["director_reference_secondary_strength_values"] = []interface{}{1 - ref.Fidelity}
+		log.Printf("Using character reference: strength=%.2f, fidelity=%.2f, caption=%s", ref.Strength, ref.Fidelity, caption)
 	}
 
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response body: "+err.Error(), http.StatusInternalServerError)
-		log.Printf("Failed to read response body: %v", err)
-		return
-	}
-	log.Printf("NAI-4 response body read successfully. Content-Type: %s, Body length: %d bytes", resp.Header.Get("Content-Type"), len(bodyBytes))
+	// 单次请求的参数覆盖
+	applyOverrides(payload, gen.Overrides)
 
-	// 检查响应是否为ZIP格式
-	if len(bodyBytes) < 4 {
-		log.Printf("Response too short to be a ZIP file: %d bytes", len(bodyBytes))
-		http.Error(w, "Invalid response from API", http.StatusInternalServerError)
-		return
-	}
+	// 图生图 / 局部重绘
+	applyAction(payload, gen, cfg)
 
-	// 检查ZIP文件头
-	if bodyBytes[0] != 0x50 || bodyBytes[1] != 0x4B {
-		log.Printf("Response is not a ZIP file. First 100 bytes: %s", string(bodyBytes[:min(100, len(bodyBytes))]))
-		http.Error(w, "API response is not a ZIP file", http.StatusInternalServerError)
-		return
+	var images [][]byte
+	var err error
+	if gen.Progress != nil {
+		images, err = streamImages(payload, authHeader, gen.Progress)
+	} else {
+		images, err = generateImages(payload, authHeader)
 	}
-
-	// 创建 ZIP 读取器
-	zipReader, err := zip.NewReader(bytes.NewReader(bodyBytes), int64(len(bodyBytes)))
 	if err != nil {
-		log.Printf("Failed to create zip reader: %v", err)
-		log.Printf("Response body (first 200 bytes): %s", string(bodyBytes[:min(200, len(bodyBytes))]))
-		http.Error(w, "Failed to read ZIP file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	log.Println("NAI-4 ZIP file read successfully.")
-
-	// 获取当前时间戳
-	timestamp := time.Now().Unix()
-	imageName := fmt.Sprintf("nai4_%d.png", timestamp)
-	log.Printf("NAI-4 image will be processed as: %s", imageName)
-
-	// 提取指定的图像文件并直接上传到存储服务
-	for _, file := range zipReader.File {
-		if file.Name == "image_0.png" { // 根据实际文件名进行匹配
-			// 打开 ZIP 中的文件
-			srcFile, err := file.Open()
-			if err != nil {
-				http.Error(w, "打开 ZIP 中的文件失败: "+err.Error(), http.StatusInternalServerError)
-				log.Printf("打开 ZIP 中的文件失败: %v", err)
-				return
-			}
-			defer srcFile.Close()
-
-			// 将图像数据读取到内存中
-			imageData, err := io.ReadAll(srcFile)
-			if err != nil {
-				http.Error(w, "读取图像数据失败: "+err.Error(), http.StatusInternalServerError)
-				log.Printf("读取图像数据失败: %v", err)
-				return
-			}
-			log.Printf("NAI-4 图像数据读取成功，大小: %d bytes", len(imageData))
-
-			var outputs string
-
-			// 使用通用上传函数上传图片
-			log.Printf("开始上传 NAI-4 图片: %s", imageName)
-
-			// 调用通用上传函数
-			response, err := upload.UploadFile(imageData, imageName, cfg)
-			if err != nil {
-				log.Printf("NAI-4 图片上传失败: %v", err)
-				outputs = fmt.Sprintf("error: 上传失败 - %s", imageName)
-
-				// 记录失败日志
-				logs.LogImage(logs.ImageLog{
-					Model:    req.Model,
-					Prompt:   userInput,
-					ImageURL: "",
-					UserIP:   r.RemoteAddr,
-					Status:   "failed",
-					Error:    fmt.Sprintf("上传失败: %v", err),
-				})
-			} else {
-				log.Printf("NAI-4 图片上传成功: %s", response.Data.URL)
-				outputs = response.Data.URL
-
-				// 记录成功日志
-				logs.LogImage(logs.ImageLog{
-					Model:    req.Model,
-					Prompt:   userInput,
-					ImageURL: outputs,
-					UserIP:   r.RemoteAddr,
-					Status:   "success",
-				})
-			}
-
-			publicLink := fmt.Sprintf("![%s](%s)", imageName, outputs)
-			fmt.Println(publicLink)
-
-			// 根据请求类型决定响应格式
-			if isDallRequest {
-				// DALL-E 格式响应
-				dallResponse := map[string]interface{}{
-					"data": []map[string]interface{}{
-						{
-							"url": outputs,
-						},
-					},
-					"created": timestamp,
-					"usage": map[string]interface{}{
-						"prompt_tokens":     0,
-						"completion_tokens": 0,
-						"total_tokens":      16384,
-						"prompt_tokens_details": map[string]interface{}{
-							"cached_tokens_details": map[string]interface{}{},
-						},
-						"completion_tokens_details": map[string]interface{}{},
-						"output_tokens":             16384,
-					},
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(dallResponse)
-			} else {
-				// 原有的流式聊天响应格式
-				sseResponse := fmt.Sprintf(
-					"data: {\"id\":\"%s\",\"object\":\"chat.completion.chunk\",\"created\":%d,\"model\":\"%s\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%s\"},\"logprobs\":null,\"finish_reason\":null}]}\n\n",
-					"chatcmpl-"+fmt.Sprintf("%d", timestamp), // 生成一个唯一的 id
-					timestamp,
-					req.Model,
-					publicLink,
-				)
-
-				w.Header().Set("Content-Type", "text/event-stream")
-				w.Write([]byte(sseResponse))
-				w.(http.Flusher).Flush() // 刷新响应缓冲区到客户端
-			}
-			break
-		}
-	}
-
-	// 如果不是 DALL-E 请求，则结束流式输出
-	if !isDallRequest {
-		w.Write([]byte("event: end\n\n"))
-		w.(http.Flusher).Flush() // 刷新最后一条消息
+		return nil, err
 	}
+	return newResult(images, gen.Seed, "nai4_"), nil
 }
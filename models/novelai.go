@@ -0,0 +1,233 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"novel-api/config"
+)
+
+// NovelAI 生成接口地址
+const generateImageURL = "https://image.novelai.net/ai/generate-image"
+
+// GenerateRequest 一次 NovelAI 生成所需的参数
+type GenerateRequest struct {
+	Model            string            // 模型名称
+	Prompt           string            // 正向提示词（已翻译）
+	Seed             int               // 随机种子
+	Width            int               // 图像宽度
+	Height           int               // 图像高度
+	NSamples         int               // 生成数量
+	References       []Reference       // 参考图（vibe transfer），可为空
+	CharacterPrompts []CharacterPrompt // 角色提示词，仅 V4 使用
+	// 角色参考，仅 NAI 4.5 使用
+	CharacterReference *CharacterReference
+	// 单次请求的参数覆盖，为 nil 时使用配置文件中的值
+	Overrides *Overrides
+	// 生成进度回调，非 nil 时 V4 模型改用流式接口
+	Progress ProgressFunc
+
+	// 图生图 / 局部重绘参数，Action 为空时等同于 generate
+	Action   string  // generate、img2img 或 infill
+	Image    string  // 源图像 base64 (PNG)
+	Mask     string  // 重绘蒙版 base64 (PNG，白色为重绘区域)，仅 infill 使用
+	Strength float64 // 重绘强度 0-1
+	Noise    float64 // 额外噪声 0-1，仅 img2img 使用
+}
+
+// Reference 参考图（vibe transfer）
+type Reference struct {
+	Image                string  // 参考图像 base64
+	Strength             float64 // 参考强度 0-1
+	InformationExtracted float64 // 信息提取量 0-1
+}
+
+// NovelAI 支持的生成动作
+const (
+	ActionGenerate = "generate"
+	ActionImg2Img  = "img2img"
+	ActionInfill   = "infill"
+)
+
+// GenerateResult 生成结果
+type GenerateResult struct {
+	Images     [][]byte // PNG 图像数据，按序号排列
+	Seeds      []int    // 每张图像实际使用的种子，放大、增强等结果为空
+	NamePrefix string   // 上传时的文件名前缀
+}
+
+// Generator 生成函数签名，V3 与 V4 模型族各自实现
+type Generator func(gen GenerateRequest, authHeader string, cfg *config.Config) (*GenerateResult, error)
+
+// APIError NovelAI 接口返回的错误
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("NovelAI API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// applyAction 根据生成动作补充 payload 中的源图像、蒙版与强度字段
+func applyAction(payload map[string]interface{}, gen GenerateRequest, cfg *config.Config) {
+	parameters := payload["parameters"].(map[string]interface{})
+
+	switch gen.Action {
+	case ActionImg2Img:
+		payload["action"] = ActionImg2Img
+		parameters["image"] = gen.Image
+		parameters["strength"] = gen.Strength
+		parameters["noise"] = gen.Noise
+		parameters["extra_noise_seed"] = gen.Seed
+		log.Printf("Using img2img: strength=%.2f, noise=%.2f", gen.Strength, gen.Noise)
+	case ActionInfill:
+		// 局部重绘使用注册信息中对应的 inpainting 模型
+		payload["action"] = ActionInfill
+		if info, ok := LookupModel(gen.Model); ok && info.InpaintingModel != "" {
+			payload["model"] = info.InpaintingModel
+		}
+		parameters["image"] = gen.Image
+		parameters["mask"] = gen.Mask
+		parameters["add_original_image"] = cfg.Parameters.AddOriginalImage
+		parameters["inpaintImg2ImgStrength"] = gen.Strength
+		parameters["extra_noise_seed"] = gen.Seed
+		log.Printf("Using infill: model=%s, strength=%.2f", payload["model"], gen.Strength)
+	default:
+		payload["action"] = ActionGenerate
+	}
+}
+
+// min 返回两个整数中的较小值
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// postNovelAI 发送请求到 NovelAI 并返回响应体
+func postNovelAI(apiURL string, payload interface{}, authHeader string) ([]byte, error) {
+	resp, err := openNovelAI(apiURL, payload, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 读取响应体
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	log.Printf("Response body read successfully. Content-Type: %s, Body length: %d bytes", resp.Header.Get("Content-Type"), len(bodyBytes))
+
+	return bodyBytes, nil
+}
+
+// openNovelAI 发送请求到 NovelAI 并检查状态码，成功时由调用方读取并关闭响应体
+func openNovelAI(apiURL string, payload interface{}, authHeader string) (*http.Response, error) {
+	// 将 payload 转换为 JSON
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	log.Println("Payload marshaled to JSON")
+
+	// 创建新的请求
+	client := &http.Client{}
+	request, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		log.Printf("Failed to create new request: %v", err)
+		return nil, err
+	}
+
+	// 设置请求头
+	request.Header.Set("Authorization", "Bearer "+authHeader)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "*/*")
+	request.Header.Set("Accept-Language", "zh-CN,zh;q=0.9")
+	request.Header.Set("Cache-Control", "no-cache")
+	request.Header.Set("Origin", "https://novelai.net")
+	request.Header.Set("Pragma", "no-cache")
+	request.Header.Set("Referer", "https://novelai.net/")
+	log.Println("Request headers set.")
+
+	// 发送请求
+	resp, err := client.Do(request)
+	if err != nil {
+		log.Printf("(发送请求失败)Failed to send request: %v", err)
+		return nil, err
+	}
+
+	// 检查HTTP响应状态
+	log.Printf("HTTP Response Status: %d %s", resp.StatusCode, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		log.Printf("API Error Response: %s", string(bodyBytes))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	return resp, nil
+}
+
+// generateImages 发送生成请求并解析返回的 ZIP 图像
+func generateImages(payload interface{}, authHeader string) ([][]byte, error) {
+	bodyBytes, err := postNovelAI(generateImageURL, payload, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	return unzipImages(bodyBytes)
+}
+
+// unzipImages 校验并解压 NovelAI 返回的 ZIP
+func unzipImages(bodyBytes []byte) ([][]byte, error) {
+	// 检查响应是否为ZIP格式
+	if len(bodyBytes) < 4 {
+		log.Printf("Response too short to be a ZIP file: %d bytes", len(bodyBytes))
+		return nil, fmt.Errorf("invalid response from API")
+	}
+
+	// 检查ZIP文件头
+	if bodyBytes[0] != 0x50 || bodyBytes[1] != 0x4B {
+		log.Printf("Response is not a ZIP file. First 100 bytes: %s", string(bodyBytes[:min(100, len(bodyBytes))]))
+		return nil, fmt.Errorf("API response is not a ZIP file")
+	}
+
+	// 创建 ZIP 读取器
+	zipReader, err := zip.NewReader(bytes.NewReader(bodyBytes), int64(len(bodyBytes)))
+	if err != nil {
+		log.Printf("Failed to create zip reader: %v", err)
+		log.Printf("Response body (first 200 bytes): %s", string(bodyBytes[:min(200, len(bodyBytes))]))
+		return nil, fmt.Errorf("failed to read ZIP file: %v", err)
+	}
+	log.Println("ZIP file read successfully.")
+
+	// 提取 ZIP 中的所有图像
+	images, err := extractImages(zipReader)
+	if err != nil {
+		log.Printf("提取图像失败: %v", err)
+		return nil, err
+	}
+	log.Printf("图像提取成功，共 %d 张", len(images))
+	return images, nil
+}
+
+// newResult 组装生成结果，优先使用 PNG 元数据中记录的种子
+func newResult(images [][]byte, seed int, namePrefix string) *GenerateResult {
+	seeds := make([]int, len(images))
+	for i, imageData := range images {
+		if s, ok := pngSeed(imageData); ok {
+			seeds[i] = s
+		} else {
+			// 元数据缺失时，按 NovelAI 批量生成的规则递增
+			seeds[i] = seed + i
+		}
+	}
+	return &GenerateResult{Images: images, Seeds: seeds, NamePrefix: namePrefix}
+}
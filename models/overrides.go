@@ -0,0 +1,151 @@
+package models
+
+import (
+	"log"
+	"strings"
+)
+
+// Samplers NovelAI 支持的采样器
+var Samplers = []string{
+	"k_euler",
+	"k_euler_ancestral",
+	"k_dpmpp_2s_ancestral",
+	"k_dpmpp_2m",
+	"k_dpmpp_2m_sde",
+	"k_dpmpp_sde",
+	"ddim_v3",
+}
+
+// NoiseSchedules NovelAI 支持的噪声调度
+var NoiseSchedules = []string{"native", "karras", "exponential", "polyexponential"}
+
+// 单次请求可覆盖的参数范围
+const (
+	MaxSteps      = 50
+	MaxScale      = 10.0
+	MaxCFGRescale = 1.0
+)
+
+// 开启 variety 且配置中未设置 skip_cfg_above_sigma 时使用的默认值
+const defaultVarietySigma = 19
+
+// Overrides 单次请求覆盖的 NovelAI 参数，nil 字段使用配置文件中的值
+type Overrides struct {
+	Steps         *int
+	Scale         *float64
+	Sampler       *string
+	NoiseSchedule *string
+	CFGRescale    *float64
+	SM            *bool // 仅 V3 使用
+	SMDyn         *bool // 仅 V3 使用
+	Variety       *bool // 对应 skip_cfg_above_sigma，关闭时发送 null
+	// 替换配置文件中的反向提示词
+	NegativePrompt *string
+	// 追加到反向提示词之后的内容
+	ExtraNegativePrompt string
+}
+
+// Merge 以 top 中已设置的字段覆盖 o，返回新的参数覆盖，两者均不修改
+func (o *Overrides) Merge(top *Overrides) *Overrides {
+	if o == nil {
+		return top
+	}
+	if top == nil {
+		return o
+	}
+	merged := *o
+	if top.Steps != nil {
+		merged.Steps = top.Steps
+	}
+	if top.Scale != nil {
+		merged.Scale = top.Scale
+	}
+	if top.Sampler != nil {
+		merged.Sampler = top.Sampler
+	}
+	if top.NoiseSchedule != nil {
+		merged.NoiseSchedule = top.NoiseSchedule
+	}
+	if top.CFGRescale != nil {
+		merged.CFGRescale = top.CFGRescale
+	}
+	if top.SM != nil {
+		merged.SM = top.SM
+	}
+	if top.SMDyn != nil {
+		merged.SMDyn = top.SMDyn
+	}
+	if top.Variety != nil {
+		merged.Variety = top.Variety
+	}
+	if top.NegativePrompt != nil {
+		merged.NegativePrompt = top.NegativePrompt
+	}
+	if top.ExtraNegativePrompt != "" {
+		merged.ExtraNegativePrompt = top.ExtraNegativePrompt
+	}
+	return &merged
+}
+
+// applyOverrides 将单次请求的参数覆盖写入 payload，反向提示词由 negativePrompt 在构建 payload 时处理
+func applyOverrides(payload map[string]interface{}, o *Overrides) {
+	if o == nil {
+		return
+	}
+	parameters := payload["parameters"].(map[string]interface{})
+
+	if o.Steps != nil {
+		parameters["steps"] = *o.Steps
+	}
+	if o.Scale != nil {
+		parameters["scale"] = *o.Scale
+	}
+	if o.Sampler != nil {
+		parameters["sampler"] = *o.Sampler
+	}
+	if o.NoiseSchedule != nil {
+		parameters["noise_schedule"] = *o.NoiseSchedule
+	}
+	if o.CFGRescale != nil {
+		parameters["cfg_rescale"] = *o.CFGRescale
+	}
+	if o.SM != nil {
+		parameters["sm"] = *o.SM
+	}
+	if o.SMDyn != nil {
+		parameters["sm_dyn"] = *o.SMDyn
+	}
+	if o.Variety != nil {
+		if !*o.Variety {
+			parameters["skip_cfg_above_sigma"] = nil
+		} else if sigma, _ := parameters["skip_cfg_above_sigma"].(int); sigma == 0 {
+			parameters["skip_cfg_above_sigma"] = defaultVarietySigma
+		}
+	}
+	log.Printf("Applied overrides: steps=%v, scale=%v, sampler=%v, noise_schedule=%v, cfg_rescale=%v, skip_cfg_above_sigma=%v",
+		parameters["steps"], parameters["scale"], parameters["sampler"], parameters["noise_schedule"], parameters["cfg_rescale"], parameters["skip_cfg_above_sigma"])
+}
+
+// negativePrompt 返回覆盖后的反向提示词，需在构建 payload 前调用，
+// V4 的默认角色反词（characterPrompts[0].uc 与 v4_negative_prompt 的 char_captions）也使用该值
+func (o *Overrides) negativePrompt(base string) string {
+	if o == nil {
+		return base
+	}
+	if o.NegativePrompt != nil {
+		base = *o.NegativePrompt
+	}
+	if o.ExtraNegativePrompt != "" {
+		base = joinPrompt(base, o.ExtraNegativePrompt)
+	}
+	return base
+}
+
+// joinPrompt 以逗号拼接两段提示词
+func joinPrompt(base, extra string) string {
+	base = strings.TrimRight(strings.TrimSpace(base), ",")
+	if base == "" {
+		return extra
+	}
+	return base + ", " + extra
+}
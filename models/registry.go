@@ -0,0 +1,68 @@
+package models
+
+// 模型族
+const (
+	FamilyV3 = "v3"
+	FamilyV4 = "v4"
+)
+
+// DefaultModel 请求未指定模型时使用的模型
+const DefaultModel = "nai-diffusion-3"
+
+// ModelInfo 模型注册信息
+type ModelInfo struct {
+	ID          string // 模型名称
+	Name        string // 展示名称
+	Family      string // 模型族：v3 或 v4，决定使用的生成函数
+	QualityTags string // 内置质量词，可被配置覆盖
+
+	// 局部重绘使用的模型，为空时不支持局部重绘
+	InpaintingModel string
+
+	// 能力
+	Img2Img            bool // 图生图
+	Vibes              bool // 参考图（vibe transfer）
+	Characters         bool // 多角色提示词
+	CharacterReference bool // 角色参考
+}
+
+// registry 已注册的模型，按展示顺序排列
+var registry = []ModelInfo{
+	{ID: "nai-diffusion-3", Name: "NAI Diffusion Anime V3", Family: FamilyV3, QualityTags: defaultV3QualityTags,
+		Img2Img: true, Vibes: true, InpaintingModel: "nai-diffusion-3-inpainting"},
+	{ID: "nai-diffusion-furry-3", Name: "NAI Diffusion Furry V3", Family: FamilyV3, QualityTags: defaultV3QualityTags,
+		Img2Img: true, Vibes: true, InpaintingModel: "nai-diffusion-furry-3-inpainting"},
+	{ID: "nai-diffusion-4-curated-preview", Name: "NAI Diffusion V4 Curated", Family: FamilyV4, QualityTags: defaultV4QualityTags,
+		Img2Img: true, Vibes: true, Characters: true, InpaintingModel: "nai-diffusion-4-curated-inpainting"},
+	{ID: "nai-diffusion-4-full", Name: "NAI Diffusion V4 Full", Family: FamilyV4, QualityTags: defaultV4QualityTags,
+		Img2Img: true, Vibes: true, Characters: true, InpaintingModel: "nai-diffusion-4-full-inpainting"},
+	{ID: "nai-diffusion-4-5-curated", Name: "NAI Diffusion V4.5 Curated", Family: FamilyV4, QualityTags: defaultV4QualityTags,
+		Img2Img: true, Vibes: true, Characters: true, CharacterReference: true, InpaintingModel: "nai-diffusion-4-5-curated-inpainting"},
+	{ID: "nai-diffusion-4-5-full", Name: "NAI Diffusion V4.5 Full", Family: FamilyV4, QualityTags: defaultV4QualityTags,
+		Img2Img: true, Vibes: true, Characters: true, CharacterReference: true, InpaintingModel: "nai-diffusion-4-5-full-inpainting"},
+}
+
+// LookupModel 按名称查找模型
+func LookupModel(id string) (ModelInfo, bool) {
+	for _, m := range registry {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// Models 返回全部已注册的模型
+func Models() []ModelInfo {
+	list := make([]ModelInfo, len(registry))
+	copy(list, registry)
+	return list
+}
+
+// Generator 返回模型族对应的生成函数
+func (m ModelInfo) Generator() Generator {
+	if m.Family == FamilyV4 {
+		return Nai4
+	}
+	return Nai3
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// NovelAI 流式生成接口地址
+const generateImageStreamURL = "https://image.novelai.net/ai/generate-image-stream"
+
+// 流式事件类型
+const (
+	EventIntermediate = "intermediate"
+	EventFinal        = "final"
+)
+
+// Progress 流式生成的进度
+type Progress struct {
+	Sample  int     // 图像序号
+	Step    int     // 当前步数，从 1 开始
+	Steps   int     // 总步数
+	Sigma   float64 // 当前噪声水平
+	Preview []byte  // 中间预览图（JPEG）
+}
+
+// ProgressFunc 接收生成进度的回调
+type ProgressFunc func(Progress)
+
+// DecodeMsg 从 MessagePack 读取一个流式事件，未知字段会被跳过
+func (z *NAI4Response) DecodeMsg(dc *msgp.Reader) error {
+	size, err := dc.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < size; i++ {
+		key, err := dc.ReadString()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "event_type":
+			z.EventType, err = dc.ReadString()
+		case "samp_ix":
+			z.SampIx, err = dc.ReadInt()
+		case "step_ix":
+			z.StepIx, err = dc.ReadInt()
+		case "gen_id":
+			z.GenID, err = readMsgString(dc)
+		case "sigma":
+			z.Sigma, err = readMsgNumber(dc)
+		case "image":
+			z.Image, err = readMsgBytes(dc)
+		default:
+			err = dc.Skip()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// readMsgString 读取字符串，兼容数字形式的 ID
+func readMsgString(dc *msgp.Reader) (string, error) {
+	value, err := dc.ReadIntf()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
+
+// readMsgNumber 读取整数或浮点数
+func readMsgNumber(dc *msgp.Reader) (float64, error) {
+	value, err := dc.ReadIntf()
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected type %T", value)
+}
+
+// readMsgBytes 读取二进制数据，兼容以字符串编码的图像
+func readMsgBytes(dc *msgp.Reader) ([]byte, error) {
+	t, err := dc.NextType()
+	if err != nil {
+		return nil, err
+	}
+	if t == msgp.StrType {
+		return dc.ReadStringAsBytes(nil)
+	}
+	return dc.ReadBytes(nil)
+}
+
+// streamImages 通过流式接口生成图像，每一步回调一次进度，返回按序号排列的最终图像
+func streamImages(payload map[string]interface{}, authHeader string, progress ProgressFunc) ([][]byte, error) {
+	parameters := payload["parameters"].(map[string]interface{})
+	parameters["stream"] = "msgpack"
+	steps, _ := parameters["steps"].(int)
+
+	resp, err := openNovelAI(generateImageStreamURL, payload, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	finals := make(map[int][]byte)
+	err = readStreamEvents(resp.Body, func(event NAI4Response) error {
+		switch event.EventType {
+		case EventIntermediate:
+			progress(Progress{
+				Sample:  event.SampIx,
+				Step:    event.StepIx + 1,
+				Steps:   steps,
+				Sigma:   event.Sigma,
+				Preview: event.Image,
+			})
+		case EventFinal:
+			finals[event.SampIx] = event.Image
+			log.Printf("Stream final image received: sample=%d, %d bytes", event.SampIx, len(event.Image))
+		default:
+			log.Printf("Ignoring stream event: %s", event.EventType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream: %v", err)
+	}
+	if len(finals) == 0 {
+		return nil, fmt.Errorf("stream ended without a final image")
+	}
+
+	samples := make([]int, 0, len(finals))
+	for sample := range finals {
+		samples = append(samples, sample)
+	}
+	sort.Ints(samples)
+	images := make([][]byte, 0, len(samples))
+	for _, sample := range samples {
+		images = append(images, finals[sample])
+	}
+	return images, nil
+}
+
+// readStreamEvents 逐个读取流式事件
+// NovelAI 的消息可能带 4 字节大端长度前缀，也可能直接连续写入，按首字节区分
+func readStreamEvents(body io.Reader, handle func(NAI4Response) error) error {
+	br := bufio.NewReader(body)
+	first, err := br.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	// MessagePack map 以 0x80-0x8f、0xde 或 0xdf 开头
+	if lead := first[0]; lead&0xf0 == 0x80 || lead == 0xde || lead == 0xdf {
+		dc := msgp.NewReader(br)
+		for {
+			var event NAI4Response
+			if err := event.DecodeMsg(dc); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if err := handle(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		message := make([]byte, size)
+		if _, err := io.ReadFull(br, message); err != nil {
+			return err
+		}
+		var event NAI4Response
+		if err := event.DecodeMsg(msgp.NewReader(bytes.NewReader(message))); err != nil {
+			return err
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"fmt"
+	"log"
+)
+
+// NovelAI 放大接口地址
+const upscaleURL = "https://api.novelai.net/ai/upscale"
+
+// UpscaleRequest 放大请求参数
+type UpscaleRequest struct {
+	Image  string // 源图像 base64 (PNG)
+	Width  int    // 源图像宽度
+	Height int    // 源图像高度
+	Scale  int    // 放大倍数，2 或 4
+}
+
+// Upscale 调用 NovelAI 放大接口，返回放大后的 PNG
+func Upscale(up UpscaleRequest, authHeader string) ([]byte, error) {
+	if up.Scale != 2 && up.Scale != 4 {
+		return nil, fmt.Errorf("unsupported upscale factor: %d", up.Scale)
+	}
+	log.Printf("Preparing upscale request: %dx%d x%d", up.Width, up.Height, up.Scale)
+
+	payload := map[string]interface{}{
+		"image":  up.Image,
+		"width":  up.Width,
+		"height": up.Height,
+		"scale":  up.Scale,
+	}
+
+	bodyBytes, err := postNovelAI(upscaleURL, payload, authHeader)
+	if err != nil {
+		return nil, err
+	}
+	images, err := unzipImages(bodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return images[0], nil
+}
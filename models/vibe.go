@@ -0,0 +1,84 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"novel-api/config"
+	"os"
+	"path/filepath"
+)
+
+// NovelAI vibe 编码接口地址
+const encodeVibeURL = "https://image.novelai.net/ai/encode-vibe"
+
+// 默认 vibe 缓存目录
+const defaultVibeCacheDir = "cache/vibes"
+
+// EncodeVibe 通过 NovelAI 编码 V4 参考图像，返回 base64 编码结果
+// 编码结果按 模型 + 图像哈希 + information_extracted 缓存到磁盘，重复引用同一张图不会再次消耗 Anlas
+func EncodeVibe(image string, informationExtracted float64, model string, authHeader string, cfg *config.Config) (string, error) {
+	imageBytes, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference image: %v", err)
+	}
+
+	imageHash := sha256.Sum256(imageBytes)
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%.4f", model, hex.EncodeToString(imageHash[:]), informationExtracted)))
+	cachePath := filepath.Join(vibeCacheDir(cfg), hex.EncodeToString(key[:])+".vibe")
+
+	// 命中磁盘缓存
+	if encoding, err := os.ReadFile(cachePath); err == nil {
+		log.Printf("Vibe cache hit: %s", filepath.Base(cachePath))
+		return base64.StdEncoding.EncodeToString(encoding), nil
+	}
+
+	log.Printf("Vibe cache miss, encoding reference image: model=%s, information_extracted=%.2f", model, informationExtracted)
+	payload := map[string]interface{}{
+		"image":                 image,
+		"information_extracted": informationExtracted,
+		"model":                 model,
+	}
+	encoding, err := postNovelAI(encodeVibeURL, payload, authHeader)
+	if err != nil {
+		return "", err
+	}
+
+	// 写入缓存失败不影响本次生成
+	if err := writeVibeCache(cachePath, encoding); err != nil {
+		log.Printf("Failed to write vibe cache: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(encoding), nil
+}
+
+// vibeCacheDir 返回 vibe 缓存目录
+func vibeCacheDir(cfg *config.Config) string {
+	if cfg.VibeCache.Dir != "" {
+		return cfg.VibeCache.Dir
+	}
+	return defaultVibeCacheDir
+}
+
+// writeVibeCache 先写临时文件再重命名，避免并发请求读到写了一半的缓存
+func writeVibeCache(cachePath string, encoding []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".vibe-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(encoding); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath)
+}